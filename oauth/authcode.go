@@ -0,0 +1,98 @@
+// Copyright 2014 The goauth2 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oauth
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Values for the Prompt option. See
+// http://openid.net/specs/openid-connect-core-1_0.html#AuthRequest.
+const (
+	PromptNone          = "none"
+	PromptLogin         = "login"
+	PromptConsent       = "consent"
+	PromptSelectAccount = "select_account"
+)
+
+// An AuthCodeOption adds parameters to the URL returned by
+// AuthCodeURLWithOptions.
+type AuthCodeOption func(v url.Values) error
+
+// AuthCodeURLWithOptions is like AuthCodeURL but adds the parameters
+// set by opts, which are applied in order and override those derived
+// from the Config. It returns an error rather than panicking if AuthURL
+// is malformed.
+//
+//	url, err := config.AuthCodeURLWithOptions(state,
+//		oauth.Nonce(nonce),
+//		oauth.Prompt(oauth.PromptConsent, oauth.PromptSelectAccount),
+//		oauth.LoginHint("user@example.com"))
+func (c *Config) AuthCodeURLWithOptions(state string, opts ...AuthCodeOption) (string, error) {
+	extra := make(url.Values)
+	for _, opt := range opts {
+		if err := opt(extra); err != nil {
+			return "", err
+		}
+	}
+	return c.authCodeURL(state, extra)
+}
+
+// Param returns an AuthCodeOption that sets the parameter key to value.
+// If value is empty the parameter is removed.
+func Param(key, value string) AuthCodeOption {
+	return func(v url.Values) error {
+		v[key] = condVal(value)
+		return nil
+	}
+}
+
+// Nonce sets the OpenID Connect nonce, which is returned in the ID token.
+func Nonce(nonce string) AuthCodeOption {
+	return Param("nonce", nonce)
+}
+
+// Prompt sets the prompt parameter to the given space-separated values,
+// such as PromptConsent. The deprecated approval_prompt parameter, which
+// may not be sent with prompt, is removed.
+func Prompt(prompts ...string) AuthCodeOption {
+	return func(v url.Values) error {
+		v.Set("prompt", strings.Join(prompts, " "))
+		v["approval_prompt"] = nil
+		return nil
+	}
+}
+
+// LoginHint sets the login_hint parameter, such as the user's email
+// address, to pre-fill the sign-in form.
+func LoginHint(hint string) AuthCodeOption {
+	return Param("login_hint", hint)
+}
+
+// HostedDomain sets Google's hd parameter, which limits sign-in to
+// accounts of the given Google Apps domain.
+func HostedDomain(domain string) AuthCodeOption {
+	return Param("hd", domain)
+}
+
+// IncludeGrantedScopes asks Google to include the scopes the user has
+// already granted to the client in the new authorization.
+func IncludeGrantedScopes() AuthCodeOption {
+	return Param("include_granted_scopes", "true")
+}
+
+// ResponseMode sets the response_mode parameter, such as "form_post".
+func ResponseMode(mode string) AuthCodeOption {
+	return Param("response_mode", mode)
+}
+
+// MaxAge sets the max_age parameter, the time since the user last
+// authenticated after which they must sign in again.
+func MaxAge(d time.Duration) AuthCodeOption {
+	return Param("max_age", strconv.FormatInt(int64(d/time.Second), 10))
+}
@@ -0,0 +1,85 @@
+// Copyright 2014 The goauth2 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oauth
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestAuthCodeURLWithOptions(t *testing.T) {
+	config := &Config{
+		ClientId:       "cl13nt1d",
+		Scope:          "openid email",
+		AuthURL:        "https://example.net/auth?hl=en",
+		RedirectURL:    "https://client.example.net/callback",
+		AccessType:     "offline",
+		ApprovalPrompt: "force",
+	}
+	s, err := config.AuthCodeURLWithOptions("st4te",
+		Nonce("n0nc3"),
+		Prompt(PromptConsent, PromptSelectAccount),
+		LoginHint("user@example.net"),
+		HostedDomain("example.net"),
+		IncludeGrantedScopes(),
+		ResponseMode("form_post"),
+		MaxAge(time.Hour),
+		Param("custom", "value"),
+		Param("access_type", ""),
+	)
+	if err != nil {
+		t.Fatalf("AuthCodeURLWithOptions: %v", err)
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		t.Fatalf("parsing AuthCodeURL: %v", err)
+	}
+	q := u.Query()
+	for k, want := range map[string]string{
+		"hl":                     "en",
+		"response_type":          "code",
+		"client_id":              "cl13nt1d",
+		"state":                  "st4te",
+		"scope":                  "openid email",
+		"redirect_uri":           "https://client.example.net/callback",
+		"nonce":                  "n0nc3",
+		"prompt":                 "consent select_account",
+		"login_hint":             "user@example.net",
+		"hd":                     "example.net",
+		"include_granted_scopes": "true",
+		"response_mode":          "form_post",
+		"max_age":                "3600",
+		"custom":                 "value",
+	} {
+		if g := q.Get(k); g != want {
+			t.Errorf("%s = %q, want %q", k, g, want)
+		}
+	}
+	for _, k := range []string{"approval_prompt", "access_type"} {
+		if _, ok := q[k]; ok {
+			t.Errorf("%s = %q, want it removed", k, q.Get(k))
+		}
+	}
+}
+
+func TestAuthCodeURLWithOptionsErrors(t *testing.T) {
+	config := &Config{ClientId: "cl13nt1d", AuthURL: "%gh&%ij"}
+	if _, err := config.AuthCodeURLWithOptions(""); err == nil {
+		t.Errorf("AuthCodeURLWithOptions with malformed AuthURL succeeded")
+	}
+	config.AuthURL = "https://example.net/auth"
+	if _, err := config.AuthCodeURLWithOptions("", CodeChallengeParams("v", "S512")); err == nil {
+		t.Errorf("AuthCodeURLWithOptions with unknown challenge method succeeded")
+	}
+	s, err := config.AuthCodeURLWithOptions("", CodeChallengeParams("v", ChallengePlain))
+	if err != nil {
+		t.Fatalf("AuthCodeURLWithOptions: %v", err)
+	}
+	u, _ := url.Parse(s)
+	if g, w := u.Query().Get("code_challenge"), "v"; g != w {
+		t.Errorf("code_challenge = %q, want %q", g, w)
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2014 The goauth2 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oauth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// AuthStyle represents how the client credentials are sent to the
+// provider.
+type AuthStyle int
+
+const (
+	// AuthStyleAutoDetect uses the style known to work for the TokenURL.
+	// For an unknown TokenURL the credentials are sent in the
+	// Authorization header and, if the server rejects the client, again
+	// as form parameters. The style that worked is remembered for the
+	// TokenURL.
+	AuthStyleAutoDetect AuthStyle = 0
+
+	// AuthStyleInHeader sends the client_id and client_secret using
+	// HTTP Basic Authorization, as recommended by RFC 6749.
+	AuthStyleInHeader AuthStyle = 1
+
+	// AuthStyleInParams sends the client_id and client_secret as form
+	// parameters in the request body.
+	AuthStyleInParams AuthStyle = 2
+
+	// authStyleClientId sends only the client_id, for a Config that
+	// authenticates the client with a ClientAssertion or a TLS client
+	// certificate.
+	authStyleClientId AuthStyle = -1
+
+	// authStyleNone sends no client credentials, for a Config with no
+	// ClientId.
+	authStyleNone AuthStyle = -2
+)
+
+// clientAssertionType is the client_assertion_type of a JWT assertion.
+// See http://tools.ietf.org/html/rfc7523#section-2.2.
+const clientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// authStyles remembers the AuthStyle detected for each TokenURL.
+var authStyles struct {
+	sync.Mutex
+	m map[string]AuthStyle
+}
+
+// lookupAuthStyle returns the AuthStyle to use for tokenURL, or
+// AuthStyleAutoDetect if it is not yet known.
+func lookupAuthStyle(tokenURL string) AuthStyle {
+	if !providerAuthHeaderWorks(tokenURL) {
+		return AuthStyleInParams
+	}
+	authStyles.Lock()
+	defer authStyles.Unlock()
+	return authStyles.m[tokenURL]
+}
+
+// setAuthStyle remembers that style works for tokenURL.
+func setAuthStyle(tokenURL string, style AuthStyle) {
+	authStyles.Lock()
+	defer authStyles.Unlock()
+	if authStyles.m == nil {
+		authStyles.m = make(map[string]AuthStyle)
+	}
+	authStyles.m[tokenURL] = style
+}
+
+// clientAuthFailed reports whether err may be caused by the server not
+// accepting the client credentials in the style they were sent. Other
+// errors, such as a 5xx status, must not cause the request to be sent
+// again: it may carry a one-time code or a rotating refresh token.
+func clientAuthFailed(err error) bool {
+	var e *ErrorResponse
+	if !errors.As(err, &e) || e.StatusCode >= 500 {
+		return false
+	}
+	switch e.Code {
+	case "invalid_client", "unauthorized_client":
+		return true
+	}
+	return e.StatusCode == http.StatusUnauthorized
+}
+
+// providerAuthHeaderWorks reports whether the OAuth2 server identified by the tokenURL
+// implements the OAuth2 spec correctly
+// See https://code.google.com/p/goauth2/issues/detail?id=31 for background.
+// In summary:
+// - Reddit only accepts client secret in the Authorization header
+// - Dropbox accepts either it in URL param or Auth header, but not both.
+// - Google only accepts URL param (not spec compliant?), not Auth header
+func providerAuthHeaderWorks(tokenURL string) bool {
+	if strings.HasPrefix(tokenURL, "https://accounts.google.com/") ||
+		strings.HasPrefix(tokenURL, "https://github.com/") ||
+		strings.HasPrefix(tokenURL, "https://api.instagram.com/") ||
+		strings.HasPrefix(tokenURL, "https://www.douban.com/") {
+		// Some sites fail to implement the OAuth2 spec fully.
+		return false
+	}
+
+	// Assume the provider implements the spec properly otherwise.
+	// Other providers are handled by AuthStyleAutoDetect or an
+	// explicit Config.AuthStyle.
+	return true
+}
@@ -0,0 +1,116 @@
+// Copyright 2014 The goauth2 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oauth
+
+import (
+	"io"
+	"net/http"
+	"testing"
+)
+
+// paramsOnly is a token endpoint that, like some providers, rejects
+// client credentials sent in the Authorization header.
+func paramsOnly(w http.ResponseWriter, r *http.Request, n int) {
+	w.Header().Set("Content-Type", "application/json")
+	if _, _, ok := r.BasicAuth(); ok || r.FormValue("client_secret") != "s3cr3t" {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, `{"error":"invalid_client"}`)
+		return
+	}
+	io.WriteString(w, `{"access_token":"token1","expires_in":3600}`)
+}
+
+func TestAuthStyleAutoDetect(t *testing.T) {
+	server, requests := newCountingServer(paramsOnly)
+	defer server.Close()
+
+	config := &Config{
+		ClientId:     "cl13nt1d",
+		ClientSecret: "s3cr3t",
+		TokenURL:     server.URL + "/token",
+	}
+	transport := &Transport{Config: config}
+	if _, err := transport.Exchange("c0d3"); err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if n := requests(); n != 2 {
+		t.Errorf("first Exchange made %d requests, want 2", n)
+	}
+
+	// The working style is remembered for the TokenURL.
+	transport = &Transport{Config: config}
+	if _, err := transport.Exchange("c0d3"); err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if n := requests() - 2; n != 1 {
+		t.Errorf("second Exchange made %d requests, want 1", n)
+	}
+	if g, w := lookupAuthStyle(config.TokenURL), AuthStyleInParams; g != w {
+		t.Errorf("lookupAuthStyle = %v, want %v", g, w)
+	}
+}
+
+func TestAuthStyleExplicit(t *testing.T) {
+	server, requests := newCountingServer(paramsOnly)
+	defer server.Close()
+
+	config := &Config{
+		ClientId:     "cl13nt1d",
+		ClientSecret: "s3cr3t",
+		TokenURL:     server.URL + "/token",
+		AuthStyle:    AuthStyleInHeader,
+	}
+	transport := &Transport{Config: config}
+	if _, err := transport.Exchange("c0d3"); err == nil {
+		t.Errorf("Exchange with AuthStyleInHeader succeeded, want error")
+	}
+	if n := requests(); n != 1 {
+		t.Errorf("Exchange made %d requests, want 1", n)
+	}
+
+	config.AuthStyle = AuthStyleInParams
+	if _, err := transport.Exchange("c0d3"); err != nil {
+		t.Errorf("Exchange with AuthStyleInParams: %v", err)
+	}
+	if n := requests() - 1; n != 1 {
+		t.Errorf("Exchange made %d requests, want 1", n)
+	}
+}
+
+// A server error must not make the request be sent again with the other
+// style, as that would replay the refresh token.
+func TestAuthStyleServerError(t *testing.T) {
+	server, requests := newCountingServer(func(w http.ResponseWriter, r *http.Request, n int) {
+		if g, w := r.FormValue("refresh_token"), "rt1"; g != w {
+			t.Errorf("refresh_token = %q, want %q", g, w)
+		}
+		w.WriteHeader(http.StatusBadGateway)
+		io.WriteString(w, "<html>Bad Gateway</html>")
+	})
+	defer server.Close()
+
+	transport := &Transport{
+		Config: &Config{ClientId: "cl13nt1d", ClientSecret: "s3cr3t", TokenURL: server.URL + "/token"},
+		Token:  &Token{RefreshToken: "rt1"},
+	}
+	if err := transport.Refresh(); err == nil {
+		t.Errorf("Refresh succeeded, want error")
+	}
+	if n := requests(); n != 1 {
+		t.Errorf("Refresh made %d requests, want 1", n)
+	}
+	if g := lookupAuthStyle(transport.TokenURL); g != AuthStyleAutoDetect {
+		t.Errorf("lookupAuthStyle = %v, want %v", g, AuthStyleAutoDetect)
+	}
+}
+
+func TestProviderAuthHeaderWorks(t *testing.T) {
+	if lookupAuthStyle("https://accounts.google.com/o/oauth2/token") != AuthStyleInParams {
+		t.Errorf("Google should default to AuthStyleInParams")
+	}
+	if lookupAuthStyle("https://unknown.example.net/token") != AuthStyleAutoDetect {
+		t.Errorf("unknown provider should be auto-detected")
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2014 The goauth2 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oauth
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ClientCredentials obtains Tokens for the client itself, rather than a
+// user, with the client credentials grant. Tokens can be narrowed to a
+// scope and to the resources (RFC 8707) they will be used with. One
+// Token is kept for each combination of scope and resources, and
+// renewed by repeating the grant when it expires.
+//
+//	cc := &oauth.ClientCredentials{Config: config}
+//	client := cc.Client("read", "https://api.example.com/")
+//	client.Get("https://api.example.com/items")
+//
+// The Config's TokenCache is not used.
+type ClientCredentials struct {
+	*Config
+
+	// Audience, if not empty, is sent as the audience parameter, which
+	// some servers use instead of resource indicators.
+	Audience string
+
+	// Transport is the HTTP transport used for the requests made by the
+	// Clients, and to the TokenURL.
+	// It will default to http.DefaultTransport if nil.
+	Transport http.RoundTripper
+
+	mu         sync.Mutex
+	transports map[string]*Transport // by scope and resources
+}
+
+// Token returns a valid Token for scope and resources, running the
+// grant if there is no Token yet or it has expired. If scope is empty,
+// the Config's Scope is requested.
+func (c *ClientCredentials) Token(scope string, resources ...string) (*Token, error) {
+	return c.TokenContext(context.Background(), scope, resources...)
+}
+
+// TokenContext is like Token but uses ctx for the request to the
+// TokenURL.
+func (c *ClientCredentials) TokenContext(ctx context.Context, scope string, resources ...string) (*Token, error) {
+	return c.transport(scope, resources).getToken(ctx)
+}
+
+// TokenSource returns a TokenSource for the Tokens for scope and
+// resources.
+func (c *ClientCredentials) TokenSource(scope string, resources ...string) TokenSource {
+	return c.transport(scope, resources).TokenSource()
+}
+
+// Client returns an *http.Client that makes requests with the Tokens for
+// scope and resources.
+func (c *ClientCredentials) Client(scope string, resources ...string) *http.Client {
+	return c.transport(scope, resources).Client()
+}
+
+// transport returns the Transport that holds the Token for scope and
+// resources, creating it if necessary.
+func (c *ClientCredentials) transport(scope string, resources []string) *Transport {
+	if scope == "" {
+		scope = c.Scope
+	}
+	scopes := strings.Fields(scope)
+	sort.Strings(scopes)
+	resources = append([]string(nil), resources...)
+	sort.Strings(resources)
+	key := strings.Join(scopes, " ") + "\x00" + strings.Join(resources, " ")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t, ok := c.transports[key]; ok {
+		return t
+	}
+	v := url.Values{"grant_type": {"client_credentials"}}
+	if len(scopes) > 0 {
+		v.Set("scope", strings.Join(scopes, " "))
+	}
+	if c.Audience != "" {
+		v.Set("audience", c.Audience)
+	}
+	if len(resources) > 0 {
+		v["resource"] = resources
+	}
+	config := c.Config.clone()
+	config.TokenCache = nil
+	// The empty Token has expired, so the grant is run on first use.
+	t := &Transport{Config: config, Token: new(Token), Transport: c.Transport, clientGrant: v}
+	if c.transports == nil {
+		c.transports = make(map[string]*Transport)
+	}
+	c.transports[key] = t
+	return t
+}
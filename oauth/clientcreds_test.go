@@ -0,0 +1,180 @@
+// Copyright 2014 The goauth2 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oauth
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clientGrant is a token endpoint for the client credentials grant. It
+// names each token after the scope and resources requested, and the
+// number of the request.
+func clientGrant(t *testing.T, audience string) func(http.ResponseWriter, *http.Request, int) {
+	return func(w http.ResponseWriter, r *http.Request, n int) {
+		if g, w := r.FormValue("grant_type"), "client_credentials"; g != w {
+			t.Errorf("grant_type = %q, want %q", g, w)
+		}
+		if g, w := r.FormValue("audience"), audience; g != w {
+			t.Errorf("audience = %q, want %q", g, w)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"%s|%s|%d","expires_in":3600}`, r.PostForm.Get("scope"), strings.Join(r.PostForm["resource"], ","), n)
+	}
+}
+
+func TestClientCredentials(t *testing.T) {
+	server, grants := newCountingServer(clientGrant(t, "https://example.net/api"))
+	defer server.Close()
+
+	cc := &ClientCredentials{
+		Config:   &Config{ClientId: "cl13nt1d", Scope: "read", TokenURL: server.URL},
+		Audience: "https://example.net/api",
+	}
+	for _, tt := range []struct {
+		scope     string
+		resources []string
+		want      string
+	}{
+		{"", nil, "read||1"},
+		{"write read", []string{"https://b.example.net", "https://a.example.net"}, "read write|https://a.example.net,https://b.example.net|2"},
+		// The order of scopes and resources does not matter.
+		{"read write", []string{"https://a.example.net", "https://b.example.net"}, "read write|https://a.example.net,https://b.example.net|2"},
+		{"read", nil, "read||1"},
+	} {
+		tok, err := cc.Token(tt.scope, tt.resources...)
+		if err != nil {
+			t.Fatalf("Token(%q, %q): %v", tt.scope, tt.resources, err)
+		}
+		if tok.AccessToken != tt.want {
+			t.Errorf("Token(%q, %q) = %q, want %q", tt.scope, tt.resources, tok.AccessToken, tt.want)
+		}
+	}
+	if n := grants(); n != 2 {
+		t.Errorf("ran %d grants, want 2", n)
+	}
+
+	// An expired Token is renewed by repeating the grant.
+	tr := cc.transport("read", nil)
+	tr.mu.Lock()
+	tr.setToken(&Token{AccessToken: "expired", Expiry: time.Now().Add(-time.Second)})
+	tr.mu.Unlock()
+	tok, err := cc.TokenSource("read").Token()
+	if err != nil {
+		t.Fatalf("Token after expiry: %v", err)
+	}
+	if g, w := tok.AccessToken, "read||3"; g != w {
+		t.Errorf("Token after expiry = %q, want %q", g, w)
+	}
+}
+
+func TestAuthenticateClientRenew(t *testing.T) {
+	server, grants := newCountingServer(clientGrant(t, ""))
+	defer server.Close()
+
+	transport := &Transport{Config: &Config{ClientId: "cl13nt1d", Scope: "read", TokenURL: server.URL}}
+	if err := transport.AuthenticateClient(); err != nil {
+		t.Fatalf("AuthenticateClient: %v", err)
+	}
+	if g, w := transport.Token.AccessToken, "read||1"; g != w {
+		t.Errorf("AccessToken = %q, want %q", g, w)
+	}
+
+	// An expired Token is renewed by repeating the grant.
+	transport.mu.Lock()
+	transport.setToken(&Token{AccessToken: "expired", Expiry: time.Now().Add(-time.Second)})
+	transport.mu.Unlock()
+	tok, err := transport.TokenSource().Token()
+	if err != nil {
+		t.Fatalf("Token: %v", err)
+	}
+	if g, w := tok.AccessToken, "read||2"; g != w || grants() != 2 {
+		t.Errorf("renewed Token = %q after %d grants, want %q after 2", g, grants(), w)
+	}
+}
+
+// The first client Token and its renewals are written to the
+// TokenCache, but a Transport that reads a client Token from the
+// TokenCache cannot renew it.
+func TestAuthenticateClientCache(t *testing.T) {
+	server, grants := newCountingServer(clientGrant(t, ""))
+	defer server.Close()
+
+	cache := &memCache{}
+	config := &Config{ClientId: "cl13nt1d", Scope: "read", TokenURL: server.URL, TokenCache: cache}
+	transport := &Transport{Config: config}
+	if err := transport.AuthenticateClient(); err != nil {
+		t.Fatalf("AuthenticateClient: %v", err)
+	}
+	if cache.tok == nil || cache.tok.AccessToken != "read||1" {
+		t.Fatalf("cached Token = %+v, want read||1", cache.tok)
+	}
+
+	transport.mu.Lock()
+	transport.setToken(&Token{AccessToken: "expired", Expiry: time.Now().Add(-time.Second)})
+	transport.mu.Unlock()
+	if _, err := transport.TokenSource().Token(); err != nil {
+		t.Fatalf("Token: %v", err)
+	}
+	if g, w := cache.tok.AccessToken, "read||2"; g != w {
+		t.Errorf("cached Token after renewal = %q, want %q", g, w)
+	}
+
+	cache.tok = &Token{AccessToken: "read||2", Expiry: time.Now().Add(-time.Second)}
+	restarted := &Transport{Config: config}
+	if _, err := restarted.TokenSource().Token(); err == nil {
+		t.Errorf("renewing a cached client Token succeeded, want error")
+	}
+	if n := grants(); n != 2 {
+		t.Errorf("ran %d grants, want 2", n)
+	}
+}
+
+// The client credentials Token must not keep the Refresh Token of an
+// earlier user Token, so that it is renewed with the client grant.
+func TestAuthenticateClientAfterExchange(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch g := r.FormValue("grant_type"); g {
+		case "authorization_code":
+			io.WriteString(w, `{"access_token":"user","refresh_token":"userrefresh","expires_in":3600}`)
+		case "client_credentials":
+			io.WriteString(w, `{"access_token":"client","expires_in":3600}`)
+		default:
+			t.Errorf("grant_type = %q, want authorization_code or client_credentials", g)
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	transport := &Transport{Config: &Config{ClientId: "cl13nt1d", TokenURL: server.URL}}
+	if _, err := transport.Exchange("c0d3"); err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if err := transport.AuthenticateClient(); err != nil {
+		t.Fatalf("AuthenticateClient: %v", err)
+	}
+	if g := transport.Token.RefreshToken; g != "" {
+		t.Errorf("RefreshToken = %q, want none", g)
+	}
+
+	transport.mu.Lock()
+	expired := transport.Token.clone()
+	expired.Expiry = time.Now().Add(-time.Second)
+	transport.setToken(expired)
+	transport.mu.Unlock()
+	tok, err := transport.TokenSource().Token()
+	if err != nil {
+		t.Fatalf("Token: %v", err)
+	}
+	if g, w := tok.AccessToken, "client"; g != w {
+		t.Errorf("renewed Token = %q, want %q", g, w)
+	}
+}
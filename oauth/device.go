@@ -0,0 +1,185 @@
+// Copyright 2014 The goauth2 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const deviceGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// slowDownIncrement is added to the polling interval each time the
+// server responds with "slow_down". It is a variable for testing.
+var slowDownIncrement = 5 * time.Second
+
+// DeviceCode is the response to a device authorization request.
+type DeviceCode struct {
+	// DeviceCode is the code the client uses to poll for the Token.
+	DeviceCode string
+
+	// UserCode is the code the user should enter at VerificationURI.
+	UserCode string
+
+	// VerificationURI is the URL the user should visit.
+	// VerificationURIComplete, if set, includes the UserCode so the
+	// user does not have to type it.
+	VerificationURI         string
+	VerificationURIComplete string
+
+	// Expiry is when the DeviceCode and UserCode expire.
+	// If zero the codes have no (known) expiry time.
+	Expiry time.Time
+
+	// Interval is how long the client must wait between polls.
+	// If zero, 5 seconds is used.
+	Interval time.Duration
+}
+
+// DeviceAuth starts the device authorization grant by requesting a
+// device and user code from the Config's DeviceAuthURL. The grant lets
+// devices without a browser obtain a Token: the user enters the code at
+// a URL on another device while the client polls the TokenURL.
+// See http://tools.ietf.org/html/rfc8628.
+//
+//	t := &oauth.Transport{Config: config}
+//	dc, err := t.DeviceAuth()
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	fmt.Printf("Visit %s and enter the code %s\n", dc.VerificationURI, dc.UserCode)
+//	if _, err := t.ExchangeDevice(dc); err != nil {
+//		log.Fatal(err)
+//	}
+//	// t now contains a valid Token
+func (t *Transport) DeviceAuth() (*DeviceCode, error) {
+	return t.DeviceAuthContext(context.Background())
+}
+
+// DeviceAuthContext is like DeviceAuth but uses ctx for the request to
+// the DeviceAuthURL.
+func (t *Transport) DeviceAuthContext(ctx context.Context) (*DeviceCode, error) {
+	if t.Config == nil {
+		return nil, OAuthError{"DeviceAuth", "no Config supplied"}
+	}
+	if t.DeviceAuthURL == "" {
+		return nil, OAuthError{"DeviceAuth", "no DeviceAuthURL supplied"}
+	}
+	v := url.Values{}
+	if t.Scope != "" {
+		v.Set("scope", t.Scope)
+	}
+	r, body, err := t.postForm(ctx, t.DeviceAuthURL, v)
+	if err != nil {
+		return nil, err
+	}
+	var b struct {
+		DeviceCode              string `json:"device_code"`
+		UserCode                string `json:"user_code"`
+		VerificationURI         string `json:"verification_uri"`
+		VerificationURL         string `json:"verification_url"` // used by Google
+		VerificationURIComplete string `json:"verification_uri_complete"`
+		ExpiresIn               int64  `json:"expires_in"` // seconds
+		Interval                int64  `json:"interval"`   // seconds
+	}
+	content, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch content {
+	case "application/x-www-form-urlencoded", "text/plain":
+		vals, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil, err
+		}
+		b.DeviceCode = vals.Get("device_code")
+		b.UserCode = vals.Get("user_code")
+		b.VerificationURI = vals.Get("verification_uri")
+		b.VerificationURL = vals.Get("verification_url")
+		b.VerificationURIComplete = vals.Get("verification_uri_complete")
+		b.ExpiresIn, _ = strconv.ParseInt(vals.Get("expires_in"), 10, 64)
+		b.Interval, _ = strconv.ParseInt(vals.Get("interval"), 10, 64)
+	default:
+		if err := json.Unmarshal(body, &b); err != nil {
+			return nil, fmt.Errorf("got bad response from server: %q", body)
+		}
+	}
+	if b.DeviceCode == "" {
+		return nil, errors.New("received empty device code from authorization server")
+	}
+	dc := &DeviceCode{
+		DeviceCode:              b.DeviceCode,
+		UserCode:                b.UserCode,
+		VerificationURI:         b.VerificationURI,
+		VerificationURIComplete: b.VerificationURIComplete,
+		Interval:                time.Duration(b.Interval) * time.Second,
+	}
+	if dc.VerificationURI == "" {
+		dc.VerificationURI = b.VerificationURL
+	}
+	if b.ExpiresIn != 0 {
+		dc.Expiry = time.Now().Add(time.Duration(b.ExpiresIn) * time.Second)
+	}
+	return dc, nil
+}
+
+// ExchangeDevice polls the TokenURL until the user has approved or
+// denied the request described by dc, or dc expires. On approval the
+// Token is stored in the Transport and the TokenCache.
+//
+// While the user has not yet acted the server responds with
+// "authorization_pending" and polling continues; "slow_down" increases
+// the polling interval. Any other error, including "access_denied" and
+// "expired_token", is returned as an *ErrorResponse.
+func (t *Transport) ExchangeDevice(dc *DeviceCode) (*Token, error) {
+	return t.ExchangeDeviceContext(context.Background(), dc)
+}
+
+// ExchangeDeviceContext is like ExchangeDevice but stops polling when
+// ctx is done.
+func (t *Transport) ExchangeDeviceContext(ctx context.Context, dc *DeviceCode) (*Token, error) {
+	if t.Config == nil {
+		return nil, OAuthError{"ExchangeDevice", "no Config supplied"}
+	}
+	interval := dc.Interval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	for {
+		if !dc.Expiry.IsZero() && time.Now().After(dc.Expiry) {
+			return nil, OAuthError{"ExchangeDevice", "device code expired"}
+		}
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+
+		tok := new(Token)
+		err := t.updateToken(ctx, tok, url.Values{
+			"grant_type":  {deviceGrantType},
+			"device_code": {dc.DeviceCode},
+		})
+		if err == nil {
+			return tok, t.putToken(tok)
+		}
+		var e *ErrorResponse
+		if !errors.As(err, &e) {
+			return nil, err
+		}
+		switch e.Code {
+		case "authorization_pending":
+		case "slow_down":
+			interval += slowDownIncrement
+		default:
+			return nil, err
+		}
+	}
+}
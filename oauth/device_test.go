@@ -0,0 +1,150 @@
+// Copyright 2014 The goauth2 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oauth
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDeviceFlow(t *testing.T) {
+	defer func(d time.Duration) { slowDownIncrement = d }(slowDownIncrement)
+	slowDownIncrement = time.Millisecond
+
+	// The token endpoint answers the first polls with errors.
+	polls := []string{"authorization_pending", "slow_down", "authorization_pending"}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/device":
+			if g, w := r.FormValue("client_id"), "cl13nt1d"; g != w {
+				t.Errorf("client_id = %q, want %q", g, w)
+			}
+			if g, w := r.FormValue("scope"), "https://example.net/scope"; g != w {
+				t.Errorf("scope = %q, want %q", g, w)
+			}
+			io.WriteString(w, `{
+				"device_code":"d3v1c3",
+				"user_code":"WDJB-MJHT",
+				"verification_uri":"https://example.net/device",
+				"expires_in":1800,
+				"interval":5
+			}`)
+		case "/token":
+			if g, w := r.FormValue("grant_type"), deviceGrantType; g != w {
+				t.Errorf("grant_type = %q, want %q", g, w)
+			}
+			if g, w := r.FormValue("device_code"), "d3v1c3"; g != w {
+				t.Errorf("device_code = %q, want %q", g, w)
+			}
+			if len(polls) > 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				io.WriteString(w, `{"error":"`+polls[0]+`"}`)
+				polls = polls[1:]
+				return
+			}
+			io.WriteString(w, `{"access_token":"token1","refresh_token":"refreshtoken1","expires_in":3600}`)
+		}
+	}))
+	defer server.Close()
+
+	cache := &memCache{}
+	transport := &Transport{Config: &Config{
+		ClientId:      "cl13nt1d",
+		Scope:         "https://example.net/scope",
+		DeviceAuthURL: server.URL + "/device",
+		TokenURL:      server.URL + "/token",
+		TokenCache:    cache,
+	}}
+	dc, err := transport.DeviceAuth()
+	if err != nil {
+		t.Fatalf("DeviceAuth: %v", err)
+	}
+	if g, w := dc.UserCode, "WDJB-MJHT"; g != w {
+		t.Errorf("UserCode = %q, want %q", g, w)
+	}
+	if g, w := dc.VerificationURI, "https://example.net/device"; g != w {
+		t.Errorf("VerificationURI = %q, want %q", g, w)
+	}
+	if g, w := dc.Interval, 5*time.Second; g != w {
+		t.Errorf("Interval = %v, want %v", g, w)
+	}
+	if exp := dc.Expiry.Sub(time.Now()); exp < 29*time.Minute || exp > 30*time.Minute {
+		t.Errorf("Expiry = %v, want ~30 minutes", exp)
+	}
+
+	dc.Interval = time.Millisecond
+	tok, err := transport.ExchangeDevice(dc)
+	if err != nil {
+		t.Fatalf("ExchangeDevice: %v", err)
+	}
+	checkToken(t, tok, "token1", "refreshtoken1", "")
+	if transport.Token != tok || cache.tok != tok {
+		t.Errorf("Token was not stored in the Transport and TokenCache")
+	}
+}
+
+func TestDeviceFlowErrors(t *testing.T) {
+	for _, code := range []string{"access_denied", "expired_token"} {
+		server, _ := newCountingServer(func(w http.ResponseWriter, r *http.Request, n int) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			if n == 1 {
+				io.WriteString(w, `{"error":"authorization_pending"}`)
+				return
+			}
+			io.WriteString(w, `{"error":"`+code+`"}`)
+		})
+		transport := &Transport{Config: &Config{
+			ClientId: "cl13nt1d",
+			Scope:    "https://example.net/scope",
+			TokenURL: server.URL + "/token",
+		}}
+		_, err := transport.ExchangeDevice(&DeviceCode{
+			DeviceCode: "d3v1c3",
+			Interval:   time.Millisecond,
+		})
+		server.Close()
+		var e *ErrorResponse
+		if !errors.As(err, &e) || e.Code != code {
+			t.Errorf("ExchangeDevice error = %v, want %s", err, code)
+		}
+	}
+}
+
+// A form-encoded device authorization response is parsed like a
+// form-encoded token response.
+func TestDeviceAuthForm(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-www-form-urlencoded")
+		io.WriteString(w, "device_code=d3v1c3&user_code=WDJB-MJHT&verification_url=https%3A%2F%2Fexample.net%2Fdevice&expires_in=1800&interval=5")
+	}))
+	defer server.Close()
+
+	transport := &Transport{Config: &Config{
+		ClientId:      "cl13nt1d",
+		DeviceAuthURL: server.URL,
+	}}
+	dc, err := transport.DeviceAuth()
+	if err != nil {
+		t.Fatalf("DeviceAuth: %v", err)
+	}
+	if g, w := dc.DeviceCode, "d3v1c3"; g != w {
+		t.Errorf("DeviceCode = %q, want %q", g, w)
+	}
+	if g, w := dc.VerificationURI, "https://example.net/device"; g != w {
+		t.Errorf("VerificationURI = %q, want %q", g, w)
+	}
+	if g, w := dc.Interval, 5*time.Second; g != w {
+		t.Errorf("Interval = %v, want %v", g, w)
+	}
+	if dc.Expiry.IsZero() {
+		t.Errorf("Expiry is zero, want about 30 minutes from now")
+	}
+}
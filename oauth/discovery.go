@@ -0,0 +1,223 @@
+// Copyright 2014 The goauth2 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metadata describes an authorization server. It is published by the
+// server in its OpenID Connect discovery document or its OAuth 2.0
+// authorization server metadata (RFC 8414).
+type Metadata struct {
+	Issuer string `json:"issuer"`
+
+	AuthURL          string `json:"authorization_endpoint"`
+	TokenURL         string `json:"token_endpoint"`
+	DeviceAuthURL    string `json:"device_authorization_endpoint"`
+	RevocationURL    string `json:"revocation_endpoint"`
+	IntrospectionURL string `json:"introspection_endpoint"`
+	UserInfoURL      string `json:"userinfo_endpoint"`
+	JWKSURL          string `json:"jwks_uri"`
+
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+
+	// MTLSEndpointAliases, if not nil, lists the endpoints to use
+	// instead of the ones above for mutual TLS (RFC 8705). See
+	// MTLSConfig.
+	MTLSEndpointAliases                   *MTLSEndpoints `json:"mtls_endpoint_aliases"`
+	TLSClientCertificateBoundAccessTokens bool           `json:"tls_client_certificate_bound_access_tokens"`
+}
+
+// Config returns a Config for the server's endpoints. The caller must
+// fill in the client credentials, Scope and RedirectURL.
+func (m *Metadata) Config() *Config {
+	c := &Config{
+		AuthURL:          m.AuthURL,
+		TokenURL:         m.TokenURL,
+		DeviceAuthURL:    m.DeviceAuthURL,
+		RevocationURL:    m.RevocationURL,
+		IntrospectionURL: m.IntrospectionURL,
+	}
+	// Prefer the spec's recommended style, but use form parameters
+	// for servers that only accept those.
+	methods := m.TokenEndpointAuthMethodsSupported
+	if contains(methods, "client_secret_post") && !contains(methods, "client_secret_basic") {
+		c.AuthStyle = AuthStyleInParams
+	} else if contains(methods, "client_secret_basic") {
+		c.AuthStyle = AuthStyleInHeader
+	}
+	return c
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// discoveryTTL is how long fetched Metadata is reused.
+var discoveryTTL = time.Hour
+
+// discovered caches Metadata by issuer.
+var discovered struct {
+	sync.Mutex
+	m map[string]discoveredMetadata
+}
+
+type discoveredMetadata struct {
+	md      *Metadata
+	expires time.Time
+}
+
+// Discover fetches the Metadata of the authorization server identified
+// by the issuer URL and returns a Config for it, as well as the Metadata.
+// The OpenID Connect discovery document at
+// <issuer>/.well-known/openid-configuration is tried first, then the
+// RFC 8414 metadata. The issuer in the metadata must match issuer
+// exactly. Metadata is cached for an hour.
+//
+//	config, _, err := oauth.Discover("https://accounts.google.com")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	config.ClientId = YOUR_CLIENT_ID
+//	config.ClientSecret = YOUR_CLIENT_SECRET
+//	config.Scope = "openid email"
+//	config.RedirectURL = "http://you.example.org/handler"
+//
+// The returned Config and Metadata belong to the caller.
+func Discover(issuer string) (*Config, *Metadata, error) {
+	return DiscoverContext(context.Background(), issuer)
+}
+
+// DiscoverContext is like Discover but uses ctx for the requests to the
+// authorization server.
+func DiscoverContext(ctx context.Context, issuer string) (*Config, *Metadata, error) {
+	return DiscoverTransport(ctx, issuer, nil)
+}
+
+// DiscoverTransport is like DiscoverContext but makes the requests to
+// the authorization server with rt, or http.DefaultTransport if rt is
+// nil.
+func DiscoverTransport(ctx context.Context, issuer string, rt http.RoundTripper) (*Config, *Metadata, error) {
+	discovered.Lock()
+	d, ok := discovered.m[issuer]
+	discovered.Unlock()
+	if !ok || time.Now().After(d.expires) {
+		md, err := fetchMetadata(ctx, rt, issuer)
+		if err != nil {
+			return nil, nil, err
+		}
+		d = discoveredMetadata{md, time.Now().Add(discoveryTTL)}
+		discovered.Lock()
+		if discovered.m == nil {
+			discovered.m = make(map[string]discoveredMetadata)
+		}
+		discovered.m[issuer] = d
+		discovered.Unlock()
+	}
+	md := d.md.clone()
+	return md.Config(), md, nil
+}
+
+// clone returns a copy of m that shares no slices with it.
+func (m *Metadata) clone() *Metadata {
+	m2 := *m
+	if m.MTLSEndpointAliases != nil {
+		a := *m.MTLSEndpointAliases
+		m2.MTLSEndpointAliases = &a
+	}
+	for _, p := range []*[]string{
+		&m2.ScopesSupported,
+		&m2.ResponseTypesSupported,
+		&m2.GrantTypesSupported,
+		&m2.CodeChallengeMethodsSupported,
+		&m2.TokenEndpointAuthMethodsSupported,
+		&m2.IDTokenSigningAlgValuesSupported,
+	} {
+		if *p != nil {
+			*p = append([]string(nil), *p...)
+		}
+	}
+	return &m2
+}
+
+// fetchMetadata fetches and validates the Metadata for issuer with rt.
+func fetchMetadata(ctx context.Context, rt http.RoundTripper, issuer string) (*Metadata, error) {
+	u, err := url.Parse(issuer)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return nil, OAuthError{"Discover", "invalid issuer " + issuer}
+	}
+	path := strings.TrimSuffix(u.Path, "/")
+	oidc, rfc8414 := *u, *u
+	oidc.Path = path + "/.well-known/openid-configuration"
+	rfc8414.Path = "/.well-known/oauth-authorization-server" + path
+
+	var md *Metadata
+	for _, wellKnown := range []string{oidc.String(), rfc8414.String()} {
+		md, err = getMetadata(ctx, rt, wellKnown)
+		if err != errNoMetadata {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if md.Issuer != issuer {
+		return nil, OAuthError{"Discover", fmt.Sprintf("issuer %q does not match %q", md.Issuer, issuer)}
+	}
+	return md, nil
+}
+
+// errNoMetadata is returned by getMetadata if there is no document.
+var errNoMetadata = OAuthError{"Discover", "no metadata found"}
+
+func getMetadata(ctx context.Context, rt http.RoundTripper, wellKnown string) (*Metadata, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", wellKnown, nil)
+	if err != nil {
+		return nil, err
+	}
+	r, err := (&http.Client{Transport: rt}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if r.StatusCode == http.StatusNotFound {
+		return nil, errNoMetadata
+	}
+	if r.StatusCode != 200 {
+		return nil, OAuthError{"Discover", "Unexpected HTTP status " + r.Status + " from " + wellKnown}
+	}
+	md := new(Metadata)
+	if err := json.Unmarshal(body, md); err != nil {
+		return nil, fmt.Errorf("got bad metadata from server: %q", body)
+	}
+	return md, nil
+}
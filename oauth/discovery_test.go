@@ -0,0 +1,137 @@
+// Copyright 2014 The goauth2 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oauth
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestDiscover(t *testing.T) {
+	var issuer string
+	requests := 0
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if g, w := r.URL.Path, "/.well-known/openid-configuration"; g != w {
+			t.Errorf("path = %q, want %q", g, w)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{
+			"issuer":"`+issuer+`",
+			"authorization_endpoint":"`+issuer+`/auth",
+			"token_endpoint":"`+issuer+`/token",
+			"device_authorization_endpoint":"`+issuer+`/device",
+			"revocation_endpoint":"`+issuer+`/revoke",
+			"introspection_endpoint":"`+issuer+`/introspect",
+			"userinfo_endpoint":"`+issuer+`/userinfo",
+			"jwks_uri":"`+issuer+`/certs",
+			"grant_types_supported":["authorization_code","refresh_token"],
+			"code_challenge_methods_supported":["plain","S256"],
+			"token_endpoint_auth_methods_supported":["client_secret_post"]
+		}`)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+	issuer = server.URL
+
+	config, md, err := Discover(issuer)
+	if err != nil {
+		t.Fatalf("Discover: %v", err)
+	}
+	want := &Config{
+		AuthURL:          issuer + "/auth",
+		TokenURL:         issuer + "/token",
+		DeviceAuthURL:    issuer + "/device",
+		RevocationURL:    issuer + "/revoke",
+		IntrospectionURL: issuer + "/introspect",
+		AuthStyle:        AuthStyleInParams,
+	}
+	if !reflect.DeepEqual(config, want) {
+		t.Errorf("Config = %+v, want %+v", config, want)
+	}
+	if g, w := md.JWKSURL, issuer+"/certs"; g != w {
+		t.Errorf("JWKSURL = %q, want %q", g, w)
+	}
+	if g, w := md.CodeChallengeMethodsSupported, []string{"plain", "S256"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("CodeChallengeMethodsSupported = %q, want %q", g, w)
+	}
+
+	// The metadata is cached, and callers get their own copy.
+	md.GrantTypesSupported[0] = "modified"
+	_, md, err = Discover(issuer)
+	if err != nil {
+		t.Fatalf("Discover: %v", err)
+	}
+	if requests != 1 {
+		t.Errorf("requests = %d, want 1", requests)
+	}
+	if g, w := md.GrantTypesSupported[0], "authorization_code"; g != w {
+		t.Errorf("cached GrantTypesSupported[0] = %q, want %q", g, w)
+	}
+}
+
+func TestDiscoverRFC8414(t *testing.T) {
+	var issuer string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/.well-known/oauth-authorization-server/tenant" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"issuer":"`+issuer+`","token_endpoint":"`+issuer+`/token"}`)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+	issuer = server.URL + "/tenant"
+
+	config, _, err := Discover(issuer)
+	if err != nil {
+		t.Fatalf("Discover: %v", err)
+	}
+	if g, w := config.TokenURL, issuer+"/token"; g != w {
+		t.Errorf("TokenURL = %q, want %q", g, w)
+	}
+}
+
+func TestDiscoverIssuerMismatch(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"issuer":"https://evil.example.com","token_endpoint":"https://evil.example.com/token"}`)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	if _, _, err := Discover(server.URL); err == nil {
+		t.Errorf("Discover with mismatched issuer succeeded")
+	}
+}
+
+// The metadata is fetched with the given transport.
+func TestDiscoverTransport(t *testing.T) {
+	const issuer = "https://transport.example.com"
+	var requests []string
+	rt := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		requests = append(requests, req.URL.String())
+		w := httptest.NewRecorder()
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"issuer":"`+issuer+`","token_endpoint":"`+issuer+`/token"}`)
+		return w.Result(), nil
+	})
+
+	config, _, err := DiscoverTransport(context.Background(), issuer, rt)
+	if err != nil {
+		t.Fatalf("DiscoverTransport: %v", err)
+	}
+	if g, w := config.TokenURL, issuer+"/token"; g != w {
+		t.Errorf("TokenURL = %q, want %q", g, w)
+	}
+	if w := []string{issuer + "/.well-known/openid-configuration"}; !reflect.DeepEqual(requests, w) {
+		t.Errorf("requests = %q, want %q", requests, w)
+	}
+}
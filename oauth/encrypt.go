@@ -0,0 +1,217 @@
+// Copyright 2014 The goauth2 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oauth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// A KeyProvider supplies the keys used by an EncryptedCache.
+type KeyProvider interface {
+	// Keys returns the keys, newest first. Tokens are encrypted with
+	// the first key; the others are only used to decrypt Tokens stored
+	// before the keys were rotated. Each key must be 16, 24 or 32
+	// bytes long, selecting AES-128, AES-192 or AES-256.
+	Keys() ([][]byte, error)
+}
+
+// StaticKeys is a KeyProvider that returns its own value.
+type StaticKeys [][]byte
+
+func (k StaticKeys) Keys() ([][]byte, error) {
+	return k, nil
+}
+
+// KeyFile is a KeyProvider that reads keys from the named file, which
+// holds one base64-encoded key per line, newest first. Blank lines and
+// lines starting with # are ignored. A key can be made with
+//
+//	openssl rand -base64 32
+//
+// The file is read each time a key is needed, so keys may be rotated
+// without restarting the program.
+type KeyFile string
+
+func (f KeyFile) Keys() ([][]byte, error) {
+	b, err := ioutil.ReadFile(string(f))
+	if err != nil {
+		return nil, OAuthError{"KeyFile.Keys", err.Error()}
+	}
+	keys, err := parseKeys(strings.Split(string(b), "\n"))
+	if err != nil {
+		return nil, OAuthError{"KeyFile.Keys", err.Error()}
+	}
+	return keys, nil
+}
+
+// KeyEnv is a KeyProvider that reads keys from the named environment
+// variable, which holds comma-separated base64-encoded keys, newest
+// first.
+type KeyEnv string
+
+func (e KeyEnv) Keys() ([][]byte, error) {
+	keys, err := parseKeys(strings.Split(os.Getenv(string(e)), ","))
+	if err != nil {
+		return nil, OAuthError{"KeyEnv.Keys", err.Error()}
+	}
+	return keys, nil
+}
+
+func parseKeys(lines []string) ([][]byte, error) {
+	var keys [][]byte
+	for _, l := range lines {
+		l = strings.TrimSpace(l)
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		k, err := base64.StdEncoding.DecodeString(l)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// encryptedPrefix marks an AccessToken holding an encrypted Token.
+const encryptedPrefix = "goauth2-aesgcm1:"
+
+// EncryptedCache is a Cache that encrypts Tokens with AES-GCM before
+// storing them in another Cache. Any change to a stored Token is
+// detected, and reported as an error by Token.
+//
+// The whole Token is encrypted into the AccessToken field of the Token
+// that is stored, so the underlying Cache needs only to store the
+// AccessToken and Expiry, which remains readable.
+//
+//	config.TokenCache = &oauth.EncryptedCache{
+//		Cache: oauth.CacheFile("cache.json"),
+//		Keys:  oauth.KeyFile("/etc/myapp/token-keys"),
+//	}
+type EncryptedCache struct {
+	Cache Cache
+	Keys  KeyProvider
+}
+
+// Token returns the decrypted Token. If it was encrypted with an older
+// key, it is encrypted with the newest key and stored again.
+func (c *EncryptedCache) Token() (*Token, error) {
+	stored, err := c.Cache.Token()
+	if err != nil {
+		return nil, err
+	}
+	keys, err := c.keys()
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(stored.AccessToken, encryptedPrefix) {
+		return nil, OAuthError{"EncryptedCache.Token", "cached Token is not encrypted"}
+	}
+	data, err := base64.RawURLEncoding.DecodeString(stored.AccessToken[len(encryptedPrefix):])
+	if err != nil {
+		return nil, OAuthError{"EncryptedCache.Token", "malformed cached Token"}
+	}
+	for i, key := range keys {
+		aead, err := newGCM(key)
+		if err != nil {
+			return nil, err
+		}
+		n := aead.NonceSize()
+		if len(data) < n {
+			break
+		}
+		plain, err := aead.Open(nil, data[:n], data[n:], additionalData(stored))
+		if err != nil {
+			continue
+		}
+		tok := new(Token)
+		if err := json.Unmarshal(plain, tok); err != nil {
+			return nil, OAuthError{"EncryptedCache.Token", err.Error()}
+		}
+		if i > 0 {
+			// Rotate. The Token is usable even if this fails.
+			c.PutToken(tok)
+		}
+		return tok, nil
+	}
+	return nil, OAuthError{"EncryptedCache.Token", "cached Token cannot be decrypted; it was modified or the key is unknown"}
+}
+
+// PutToken encrypts tok with the newest key and stores it.
+func (c *EncryptedCache) PutToken(tok *Token) error {
+	keys, err := c.keys()
+	if err != nil {
+		return err
+	}
+	aead, err := newGCM(keys[0])
+	if err != nil {
+		return err
+	}
+	plain, err := json.Marshal(tok)
+	if err != nil {
+		return OAuthError{"EncryptedCache.PutToken", err.Error()}
+	}
+	stored := &Token{Expiry: tok.Expiry}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return OAuthError{"EncryptedCache.PutToken", err.Error()}
+	}
+	data := aead.Seal(nonce, nonce, plain, additionalData(stored))
+	stored.AccessToken = encryptedPrefix + base64.RawURLEncoding.EncodeToString(data)
+	return c.Cache.PutToken(stored)
+}
+
+// DeleteToken deletes the Token from the underlying Cache if it is a
+// CacheDeleter, and otherwise overwrites it with an empty Token.
+func (c *EncryptedCache) DeleteToken() error {
+	if d, ok := c.Cache.(CacheDeleter); ok {
+		return d.DeleteToken()
+	}
+	return c.PutToken(new(Token))
+}
+
+// LockCache locks the underlying Cache if it is a CacheLocker.
+func (c *EncryptedCache) LockCache() (unlock func(), err error) {
+	if l, ok := c.Cache.(CacheLocker); ok {
+		return l.LockCache()
+	}
+	return func() {}, nil
+}
+
+func (c *EncryptedCache) keys() ([][]byte, error) {
+	if c.Keys == nil {
+		return nil, OAuthError{"EncryptedCache", "no KeyProvider supplied"}
+	}
+	keys, err := c.Keys.Keys()
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, OAuthError{"EncryptedCache", "no keys supplied"}
+	}
+	return keys, nil
+}
+
+// additionalData binds the ciphertext to the plaintext Expiry of the
+// stored Token, so that it cannot be changed either.
+func additionalData(stored *Token) []byte {
+	return []byte(encryptedPrefix + strconv.FormatInt(stored.Expiry.Unix(), 10))
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, OAuthError{"EncryptedCache", err.Error()}
+	}
+	return cipher.NewGCM(block)
+}
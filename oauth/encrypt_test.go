@@ -0,0 +1,150 @@
+// Copyright 2014 The goauth2 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oauth
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	key1 = bytes.Repeat([]byte{1}, 32)
+	key2 = bytes.Repeat([]byte{2}, 16)
+)
+
+func TestEncryptedCache(t *testing.T) {
+	td, err := ioutil.TempDir("", "oauth-test")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %v", err)
+	}
+	defer os.RemoveAll(td)
+	file := CacheFile(filepath.Join(td, "cache-file"))
+	cache := &EncryptedCache{Cache: file, Keys: StaticKeys{key1}}
+
+	tok := &Token{
+		AccessToken:  "token1",
+		RefreshToken: "refreshtoken1",
+		Expiry:       time.Now().Add(time.Hour),
+		Extra:        map[string]string{"id_token": "id"},
+	}
+	if err := cache.PutToken(tok); err != nil {
+		t.Fatalf("PutToken: %v", err)
+	}
+	b, err := ioutil.ReadFile(string(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(b, []byte("refreshtoken1")) || bytes.Contains(b, []byte("token1")) {
+		t.Errorf("cache file holds plaintext Token: %s", b)
+	}
+	got, err := cache.Token()
+	if err != nil {
+		t.Fatalf("Token: %v", err)
+	}
+	checkToken(t, got, "token1", "refreshtoken1", "id")
+
+	// The plaintext Expiry is authenticated.
+	stored, _ := file.Token()
+	stored.Expiry = stored.Expiry.Add(time.Hour)
+	file.PutToken(stored)
+	if _, err := cache.Token(); err == nil {
+		t.Errorf("Token with modified Expiry succeeded")
+	}
+}
+
+func TestEncryptedCacheTamper(t *testing.T) {
+	mem := &memCache{}
+	cache := &EncryptedCache{Cache: mem, Keys: StaticKeys{key1}}
+	if err := cache.PutToken(&Token{AccessToken: "token1"}); err != nil {
+		t.Fatalf("PutToken: %v", err)
+	}
+	enc := mem.tok.AccessToken
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(enc, encryptedPrefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 1
+	mem.tok.AccessToken = encryptedPrefix + base64.RawURLEncoding.EncodeToString(data)
+	if _, err := cache.Token(); err == nil {
+		t.Errorf("Token with modified ciphertext succeeded")
+	}
+
+	mem.tok.AccessToken = enc
+	cache.Keys = StaticKeys{key2}
+	if _, err := cache.Token(); err == nil {
+		t.Errorf("Token with unknown key succeeded")
+	}
+
+	mem.tok = &Token{AccessToken: "plaintext"}
+	if _, err := cache.Token(); err == nil {
+		t.Errorf("Token with unencrypted cache succeeded")
+	}
+
+	cache.Keys = StaticKeys{}
+	if err := cache.PutToken(&Token{}); err == nil {
+		t.Errorf("PutToken with no keys succeeded")
+	}
+}
+
+func TestEncryptedCacheRotation(t *testing.T) {
+	mem := &memCache{}
+	cache := &EncryptedCache{Cache: mem, Keys: StaticKeys{key1}}
+	if err := cache.PutToken(&Token{AccessToken: "token1"}); err != nil {
+		t.Fatalf("PutToken: %v", err)
+	}
+
+	// key2 is the new key; reading re-encrypts with it.
+	cache.Keys = StaticKeys{key2, key1}
+	tok, err := cache.Token()
+	if err != nil {
+		t.Fatalf("Token after rotation: %v", err)
+	}
+	if g, w := tok.AccessToken, "token1"; g != w {
+		t.Errorf("AccessToken = %q, want %q", g, w)
+	}
+	cache.Keys = StaticKeys{key2}
+	if _, err := cache.Token(); err != nil {
+		t.Errorf("Token was not re-encrypted with the new key: %v", err)
+	}
+}
+
+func TestKeyProviders(t *testing.T) {
+	td, err := ioutil.TempDir("", "oauth-test")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %v", err)
+	}
+	defer os.RemoveAll(td)
+	enc1 := base64.StdEncoding.EncodeToString(key1)
+	enc2 := base64.StdEncoding.EncodeToString(key2)
+
+	name := filepath.Join(td, "keys")
+	if err := ioutil.WriteFile(name, []byte("# newest first\n"+enc2+"\n\n"+enc1+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Unsetenv("GOAUTH2_TEST_KEYS")
+	os.Setenv("GOAUTH2_TEST_KEYS", enc2+", "+enc1)
+
+	for _, p := range []KeyProvider{KeyFile(name), KeyEnv("GOAUTH2_TEST_KEYS")} {
+		keys, err := p.Keys()
+		if err != nil {
+			t.Errorf("%T.Keys: %v", p, err)
+			continue
+		}
+		if len(keys) != 2 || !bytes.Equal(keys[0], key2) || !bytes.Equal(keys[1], key1) {
+			t.Errorf("%T.Keys = %x, want [%x %x]", p, keys, key2, key1)
+		}
+	}
+
+	os.Setenv("GOAUTH2_TEST_KEYS", "not base64!")
+	if _, err := KeyEnv("GOAUTH2_TEST_KEYS").Keys(); err == nil {
+		t.Errorf("KeyEnv.Keys with bad key succeeded")
+	}
+}
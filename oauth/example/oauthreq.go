@@ -20,11 +20,9 @@ var (
 	clientId     = flag.String("id", "", "Client ID")
 	clientSecret = flag.String("secret", "", "Client Secret")
 	scope        = flag.String("scope", "https://www.googleapis.com/auth/userinfo.profile", "OAuth scope")
-	redirectURL  = flag.String("redirect_url", "oob", "Redirect URL")
 	authURL      = flag.String("auth_url", "https://accounts.google.com/o/oauth2/auth", "Authentication URL")
 	tokenURL     = flag.String("token_url", "https://accounts.google.com/o/oauth2/token", "Token URL")
 	requestURL   = flag.String("request_url", "https://www.googleapis.com/oauth2/v1/userinfo", "API request")
-	code         = flag.String("code", "", "Authorization Code")
 	cachefile    = flag.String("cache", "cache.json", "Token cache file")
 )
 
@@ -45,7 +43,6 @@ func main() {
 	config := &oauth.Config{
 		ClientId:     *clientId,
 		ClientSecret: *clientSecret,
-		RedirectURL:  *redirectURL,
 		Scope:        *scope,
 		AuthURL:      *authURL,
 		TokenURL:     *tokenURL,
@@ -63,21 +60,15 @@ func main() {
 			fmt.Fprint(os.Stderr, usageMsg)
 			os.Exit(2)
 		}
-		if *code == "" {
-			// Get an authorization code from the data provider.
-			// ("Please ask the user if I can access this resource.")
-			url := config.AuthCodeURL("")
-			fmt.Print("Visit this URL to get a code, then run again with -code=YOUR_CODE\n\n")
-			fmt.Println(url)
-			return
-		}
-		// Exchange the authorization code for an access token.
-		// ("Here's the code you gave the user, now give me a token!")
-		token, err = transport.Exchange(*code)
+		// Get an authorization code from the data provider and
+		// exchange it for an access token. The user is sent to a URL
+		// that redirects back to this program once they agree.
+		// ("Please ask the user if I can access this resource.")
+		token, err = transport.ExchangeLoopback(oauth.PrintURL)
 		if err != nil {
-			log.Fatal("Exchange:", err)
+			log.Fatal("ExchangeLoopback:", err)
 		}
-		// (The Exchange method will automatically cache the token.)
+		// (ExchangeLoopback will automatically cache the token.)
 		fmt.Printf("Token is cached in %v\n", config.TokenCache)
 	}
 
@@ -0,0 +1,147 @@
+// Copyright 2014 The goauth2 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// Introspection describes a token, as reported by the authorization
+// server's introspection endpoint. Only Active is guaranteed to be set;
+// the server may omit any other field.
+type Introspection struct {
+	// Active reports whether the token is currently valid.
+	Active bool
+
+	Scope     string
+	ClientId  string
+	Username  string
+	TokenType string
+	Subject   string
+	Issuer    string
+	Audience  []string
+
+	// Expiry, IssuedAt and NotBefore are zero if not reported.
+	Expiry    time.Time
+	IssuedAt  time.Time
+	NotBefore time.Time
+
+	// CertThumbprint is the x5t#S256 confirmation of a token bound to
+	// a client certificate (RFC 8705). See CertThumbprint.
+	CertThumbprint string
+
+	// Extra contains the members of the response not described above.
+	Extra map[string]interface{}
+}
+
+// Introspect asks the Config's IntrospectionURL about token, as
+// described in RFC 7662. hint, which may be empty, is AccessTokenHint or
+// RefreshTokenHint. The request is authenticated with the Config's
+// client credentials, so a resource server can use a Transport with no
+// Token:
+//
+//	t := &oauth.Transport{Config: config}
+//	tok := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+//	info, err := t.Introspect(tok, oauth.AccessTokenHint)
+//	if err != nil || !info.Active {
+//		http.Error(w, "invalid token", http.StatusUnauthorized)
+//		return
+//	}
+//
+// A rejected request is reported as an *ErrorResponse.
+func (t *Transport) Introspect(token, hint string) (*Introspection, error) {
+	return t.IntrospectContext(context.Background(), token, hint)
+}
+
+// IntrospectContext is like Introspect but uses ctx for the request to
+// the IntrospectionURL.
+func (t *Transport) IntrospectContext(ctx context.Context, token, hint string) (*Introspection, error) {
+	if t.Config == nil {
+		return nil, OAuthError{"Introspect", "no Config supplied"}
+	}
+	if t.IntrospectionURL == "" {
+		return nil, OAuthError{"Introspect", "no IntrospectionURL supplied"}
+	}
+	v := url.Values{"token": {token}}
+	if hint != "" {
+		v.Set("token_type_hint", hint)
+	}
+	_, body, err := t.postForm(ctx, t.IntrospectionURL, v)
+	if err != nil {
+		return nil, err
+	}
+	return parseIntrospection(body)
+}
+
+// parseIntrospection decodes the JSON introspection response body.
+func parseIntrospection(body []byte) (*Introspection, error) {
+	var b struct {
+		Active    bool            `json:"active"`
+		Scope     string          `json:"scope"`
+		ClientId  string          `json:"client_id"`
+		Username  string          `json:"username"`
+		TokenType string          `json:"token_type"`
+		Subject   string          `json:"sub"`
+		Issuer    string          `json:"iss"`
+		Audience  json.RawMessage `json:"aud"`
+		Exp       int64           `json:"exp"`
+		Iat       int64           `json:"iat"`
+		Nbf       int64           `json:"nbf"`
+		Cnf       struct {
+			X5t string `json:"x5t#S256"`
+		} `json:"cnf"`
+	}
+	var extra map[string]interface{}
+	if json.Unmarshal(body, &b) != nil || json.Unmarshal(body, &extra) != nil {
+		return nil, fmt.Errorf("got bad response from server: %q", body)
+	}
+	in := &Introspection{
+		Active:    b.Active,
+		Scope:     b.Scope,
+		ClientId:  b.ClientId,
+		Username:  b.Username,
+		TokenType: b.TokenType,
+		Subject:   b.Subject,
+		Issuer:    b.Issuer,
+		Expiry:    unixTime(b.Exp),
+		IssuedAt:  unixTime(b.Iat),
+		NotBefore: unixTime(b.Nbf),
+
+		CertThumbprint: b.Cnf.X5t,
+	}
+	// The audience may be a single string or an array of strings.
+	if len(b.Audience) > 0 {
+		var aud string
+		if json.Unmarshal(b.Audience, &aud) == nil {
+			in.Audience = []string{aud}
+		} else if json.Unmarshal(b.Audience, &in.Audience) != nil {
+			return nil, fmt.Errorf("got bad audience from server: %s", b.Audience)
+		}
+	}
+	for _, k := range []string{"active", "scope", "client_id", "username", "token_type", "sub", "iss", "aud", "exp", "iat", "nbf"} {
+		delete(extra, k)
+	}
+	// Keep any other confirmation methods.
+	if cnf, ok := extra["cnf"].(map[string]interface{}); ok && len(cnf) == 1 && b.Cnf.X5t != "" {
+		delete(extra, "cnf")
+	}
+	if len(extra) > 0 {
+		in.Extra = extra
+	}
+	return in, nil
+}
+
+// unixTime returns the time for the given number of seconds since the
+// epoch, or the zero time if sec is zero.
+func unixTime(sec int64) time.Time {
+	if sec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0)
+}
@@ -0,0 +1,73 @@
+// Copyright 2014 The goauth2 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jwt
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+	"time"
+)
+
+// clientAssertionLifetime is how long a client assertion is valid. Each
+// assertion is used for a single request, so it is kept short.
+var clientAssertionLifetime = 5 * time.Minute
+
+// PrivateKeyJWT returns a function for oauth.Config.ClientAssertion that
+// authenticates the client with the private_key_jwt method, signing each
+// assertion with key, a PEM encoded RSA private key, using RS256.
+//
+//	config.ClientAssertion = jwt.PrivateKeyJWT(config.ClientId, pemKeyBytes)
+func PrivateKeyJWT(clientId string, key []byte) func(audience string) (string, error) {
+	return func(aud string) (string, error) {
+		return clientAssertion(NewToken(clientId, "", key), aud)
+	}
+}
+
+// SignerJWT is like PrivateKeyJWT but signs the assertions with signer,
+// which may also set the Header's KeyId.
+func SignerJWT(clientId string, signer Signer) func(audience string) (string, error) {
+	return func(aud string) (string, error) {
+		return clientAssertion(NewSignerToken(clientId, "", signer), aud)
+	}
+}
+
+// ClientSecretJWT returns a function for oauth.Config.ClientAssertion that
+// authenticates the client with the client_secret_jwt method, signing
+// each assertion with secret using HS256. The secret itself is never
+// sent.
+func ClientSecretJWT(clientId, secret string) func(audience string) (string, error) {
+	return func(aud string) (string, error) {
+		return clientAssertion(NewSignerToken(clientId, "", secretSigner(secret)), aud)
+	}
+}
+
+// clientAssertion encodes t as a client assertion for aud, with a new
+// jti and a short lifetime.
+func clientAssertion(t *Token, aud string) (string, error) {
+	jti := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, jti); err != nil {
+		return "", err
+	}
+	c := t.ClaimSet
+	c.Sub = c.Iss
+	c.Aud = aud
+	c.PrivateClaims = map[string]interface{}{"jti": base64Encode(jti)}
+	c.iat = time.Now()
+	c.exp = c.iat.Add(clientAssertionLifetime)
+	return t.Encode()
+}
+
+// secretSigner is a Signer that signs with HMAC SHA-256.
+type secretSigner string
+
+func (s secretSigner) Sign(t *Token) ([]byte, []byte, error) {
+	t.Header.Algorithm = "HS256"
+	data := t.EncodeWithoutSignature()
+	h := hmac.New(sha256.New, []byte(s))
+	h.Write([]byte(data))
+	return []byte(data), h.Sum(nil), nil
+}
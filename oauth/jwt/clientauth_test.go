@@ -0,0 +1,148 @@
+// Copyright 2014 The goauth2 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jwt
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"code.google.com/p/goauth2/oauth"
+)
+
+func testClientAssertion(t *testing.T, assertion func(string) (string, error), verify func(string, []byte) error) {
+	// The token and revocation endpoints require a client assertion
+	// checked by verify, and record the jti of each assertion.
+	var jtis []string
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if _, _, ok := r.BasicAuth(); ok {
+			t.Errorf("%s: got Authorization header, want none", r.URL.Path)
+		}
+		if s := r.PostForm.Get("client_secret"); s != "" {
+			t.Errorf("%s: client_secret = %q, want none", r.URL.Path, s)
+		}
+		if g, w := r.PostForm.Get("client_id"), iss; g != w {
+			t.Errorf("%s: client_id = %q, want %q", r.URL.Path, g, w)
+		}
+		if g, w := r.PostForm.Get("client_assertion_type"), "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"; g != w {
+			t.Errorf("%s: client_assertion_type = %q, want %q", r.URL.Path, g, w)
+		}
+		parts := strings.Split(r.PostForm.Get("client_assertion"), ".")
+		if len(parts) != 3 {
+			t.Errorf("%s: client_assertion has %d parts, want 3", r.URL.Path, len(parts))
+			return
+		}
+		sig, err := base64Decode(parts[2])
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if err := verify(parts[0]+"."+parts[1], sig); err != nil {
+			t.Errorf("%s: verifying client_assertion: %v", r.URL.Path, err)
+		}
+		b, err := base64Decode(parts[1])
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		var c struct {
+			Iss, Sub, Aud, Jti string
+			Exp, Iat           int64
+		}
+		if err := json.Unmarshal(b, &c); err != nil {
+			t.Error(err)
+			return
+		}
+		if c.Iss != iss || c.Sub != iss {
+			t.Errorf("%s: iss, sub = %q, %q, want %q", r.URL.Path, c.Iss, c.Sub, iss)
+		}
+		if w := server.URL + "/token"; c.Aud != w {
+			t.Errorf("%s: aud = %q, want %q", r.URL.Path, c.Aud, w)
+		}
+		if d := time.Duration(c.Exp-c.Iat) * time.Second; d <= 0 || d > clientAssertionLifetime {
+			t.Errorf("%s: assertion lifetime = %v, want at most %v", r.URL.Path, d, clientAssertionLifetime)
+		}
+		jtis = append(jtis, c.Jti)
+		if r.URL.Path == "/token" {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"access_token":"at","expires_in":3600}`)
+		}
+	}))
+	defer server.Close()
+
+	tr := &oauth.Transport{Config: &oauth.Config{
+		ClientId:        iss,
+		TokenURL:        server.URL + "/token",
+		RevocationURL:   server.URL + "/revoke",
+		ClientAssertion: assertion,
+	}}
+	for i := 0; i < 2; i++ {
+		if err := tr.AuthenticateClient(); err != nil {
+			t.Fatalf("AuthenticateClient: %v", err)
+		}
+	}
+	if err := tr.Revoke(oauth.AccessTokenHint); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+
+	// Each request has a new assertion.
+	seen := make(map[string]bool)
+	for _, jti := range jtis {
+		if jti == "" || seen[jti] {
+			t.Errorf("jti = %q, want a new non-empty value for each request", jti)
+		}
+		seen[jti] = true
+	}
+	if len(seen) != 3 {
+		t.Errorf("got %d assertions, want 3", len(seen))
+	}
+}
+
+func TestPrivateKeyJWT(t *testing.T) {
+	block, _ := pem.Decode(publicKeyPemBytes)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := cert.PublicKey.(*rsa.PublicKey)
+	testClientAssertion(t, PrivateKeyJWT(iss, privateKeyPemBytes), func(data string, sig []byte) error {
+		h := sha256.Sum256([]byte(data))
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, h[:], sig)
+	})
+}
+
+func TestClientSecretJWT(t *testing.T) {
+	testClientAssertion(t, ClientSecretJWT(iss, "s3cr3t"), func(data string, sig []byte) error {
+		b, err := base64Decode(strings.Split(data, ".")[0])
+		if err != nil {
+			return err
+		}
+		var h Header
+		if err := json.Unmarshal(b, &h); err != nil {
+			return err
+		}
+		if h.Algorithm != "HS256" {
+			return fmt.Errorf("alg = %q, want HS256", h.Algorithm)
+		}
+		mac := hmac.New(sha256.New, []byte("s3cr3t"))
+		mac.Write([]byte(data))
+		if !hmac.Equal(mac.Sum(nil), sig) {
+			return fmt.Errorf("bad signature")
+		}
+		return nil
+	})
+}
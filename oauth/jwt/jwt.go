@@ -35,6 +35,7 @@ package jwt
 
 import (
 	"bytes"
+	"context"
 	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
@@ -45,9 +46,12 @@ import (
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"code.google.com/p/goauth2/oauth"
@@ -349,13 +353,24 @@ func (t *Token) parsePrivateKey() error {
 // refreshed (no refresh_token is returned with the response).  Once this token
 // expires call this method again to get a fresh one.
 func (t *Token) Assert(c *http.Client) (*oauth.Token, error) {
+	return t.AssertContext(context.Background(), c)
+}
+
+// AssertContext is like Assert but uses ctx for the request to the
+// remote server.
+func (t *Token) AssertContext(ctx context.Context, c *http.Client) (*oauth.Token, error) {
 	var o *oauth.Token
 	t.ClaimSet.setTimes(time.Now())
 	u, v, err := t.buildRequest()
 	if err != nil {
 		return o, err
 	}
-	resp, err := c.PostForm(u, v)
+	req, err := http.NewRequestWithContext(ctx, "POST", u, strings.NewReader(v.Encode()))
+	if err != nil {
+		return o, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := c.Do(req)
 	if err != nil {
 		return o, err
 	}
@@ -363,6 +378,24 @@ func (t *Token) Assert(c *http.Client) (*oauth.Token, error) {
 	return o, err
 }
 
+// TokenSource returns an oauth.TokenSource that asserts t to obtain an
+// access token, and asserts it again once that token expires.
+func (t *Token) TokenSource() oauth.TokenSource {
+	return oauth.ReuseTokenSource(nil, assertSource{t})
+}
+
+type assertSource struct {
+	t *Token
+}
+
+func (s assertSource) Token() (*oauth.Token, error) {
+	return s.t.Assert(new(http.Client))
+}
+
+func (s assertSource) TokenContext(ctx context.Context) (*oauth.Token, error) {
+	return s.t.AssertContext(ctx, new(http.Client))
+}
+
 // buildRequest sets up the URL values and the proper URL string for making our
 // access_token request.
 func (t *Token) buildRequest() (string, url.Values, error) {
@@ -390,7 +423,11 @@ func handleResponse(r *http.Response) (*oauth.Token, error) {
 	o := &oauth.Token{}
 	defer r.Body.Close()
 	if r.StatusCode != 200 {
-		return o, errors.New("invalid response: " + r.Status)
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil {
+			return o, err
+		}
+		return o, oauth.ParseErrorResponse(r, body)
 	}
 	b := &respBody{}
 	err := json.NewDecoder(r.Body).Decode(b)
@@ -435,6 +472,22 @@ type Transport struct {
 	// Transport is the HTTP transport to use when making requests.
 	// It will default to http.DefaultTransport if nil.
 	Transport http.RoundTripper
+
+	// ExpiryWindow is how long before its Expiry the OAuth token is
+	// considered expired and renewed. If zero, the token is renewed
+	// only once it has expired.
+	ExpiryWindow time.Duration
+
+	// mu guards OAuthToken and flight. It is not held while
+	// asserting.
+	mu sync.Mutex
+
+	// flight is the assertion in progress, if any.
+	flight *assertCall
+
+	// refresher is the background refresher started by
+	// StartRefresher.
+	refresher oauth.Refresher
 }
 
 // Creates a new authenticated transport.
@@ -467,45 +520,125 @@ func (t *Transport) transport() http.RoundTripper {
 //
 // This method will attempt to renew the token if it has expired and may return
 // an error related to that token renewal before attempting the client request.
+// The renewal is bound to the request's context.
 // If the token cannot be renewed a non-nil os.Error value will be returned.
 // If the token is invalid callers should expect HTTP-level errors,
 // as indicated by the Response's StatusCode.
 func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	st := &oauth.SourceTransport{Source: t.TokenSource(), Base: t.transport()}
+	return st.RoundTrip(req)
+}
+
+// TokenSource returns an oauth.TokenSource for the Transport's
+// OAuthToken, which is renewed as it would be by RoundTrip.
+func (t *Transport) TokenSource() oauth.TokenSource {
+	return transportTokenSource{t}
+}
+
+type transportTokenSource struct {
+	t *Transport
+}
+
+func (s transportTokenSource) Token() (*oauth.Token, error) {
+	return s.t.getToken(context.Background())
+}
+
+func (s transportTokenSource) TokenContext(ctx context.Context) (*oauth.Token, error) {
+	return s.t.getToken(ctx)
+}
+
+func (t *Transport) getToken(ctx context.Context) (*oauth.Token, error) {
+	t.mu.Lock()
+	jwtTok, tok := t.JWTToken, t.OAuthToken
+	t.mu.Unlock()
+
 	// Sanity check the two tokens
-	if t.JWTToken == nil {
+	if jwtTok == nil {
 		return nil, fmt.Errorf("no JWT token supplied")
 	}
-	if t.OAuthToken == nil {
+	if tok == nil {
 		return nil, fmt.Errorf("no OAuth token supplied")
 	}
-	// Refresh the OAuth token if it has expired
-	if t.OAuthToken.Expired() {
-		if oa, err := t.JWTToken.Assert(new(http.Client)); err != nil {
-			return nil, err
-		} else {
-			t.OAuthToken = oa
-		}
+	if !tok.ExpiresWithin(t.ExpiryWindow) {
+		return tok, nil
 	}
-	// To set the Authorization header, we must make a copy of the Request
-	// so that we don't modify the Request we were given.
-	// This is required by the specification of http.RoundTripper.
-	req = cloneRequest(req)
-	req.Header.Set("Authorization", "Bearer "+t.OAuthToken.AccessToken)
-
-	// Make the HTTP request.
-	return t.transport().RoundTrip(req)
-}
-
-// cloneRequest returns a clone of the provided *http.Request.
-// The clone is a shallow copy of the struct and its Header map.
-func cloneRequest(r *http.Request) *http.Request {
-	// shallow copy of the struct
-	r2 := new(http.Request)
-	*r2 = *r
-	// deep copy of the Header
-	r2.Header = make(http.Header)
-	for k, s := range r.Header {
-		r2.Header[k] = s
+	return t.renew(ctx, tok)
+}
+
+// assertCall is an assertion in progress. Its result is shared by every
+// caller that needed a new OAuth token while it was running.
+type assertCall struct {
+	done chan struct{} // closed when tok and err are set
+	tok  *oauth.Token
+	err  error
+}
+
+// renew returns an OAuth token that is fresher than stale, asserting the
+// JWT token for one unless another caller has already replaced stale or
+// is doing so. Requests using the current OAuth token are not blocked
+// by the assertion, and callers waiting for it give up when their own
+// context is done.
+func (t *Transport) renew(ctx context.Context, stale *oauth.Token) (*oauth.Token, error) {
+	for {
+		t.mu.Lock()
+		jwtTok, cur := t.JWTToken, t.OAuthToken
+		if jwtTok == nil {
+			t.mu.Unlock()
+			return nil, fmt.Errorf("no JWT token supplied")
+		}
+		if cur != nil && cur != stale {
+			t.mu.Unlock()
+			return cur, nil
+		}
+		c := t.flight
+		if c == nil {
+			c = &assertCall{done: make(chan struct{})}
+			t.flight = c
+			t.mu.Unlock()
+			c.tok, c.err = jwtTok.AssertContext(ctx, new(http.Client))
+			t.mu.Lock()
+			t.flight = nil
+			if c.err == nil {
+				t.OAuthToken = c.tok
+			}
+			t.mu.Unlock()
+			close(c.done)
+			return c.tok, c.err
+		}
+		t.mu.Unlock()
+
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		// If the assertion was abandoned because its caller went away,
+		// try again on behalf of this one.
+		if (errors.Is(c.err, context.Canceled) || errors.Is(c.err, context.DeadlineExceeded)) && ctx.Err() == nil {
+			stale = cur
+			continue
+		}
+		return c.tok, c.err
 	}
-	return r2
+}
+
+// StartRefresher starts a goroutine that renews the OAuth token
+// ExpiryWindow before it expires (or one minute before, if ExpiryWindow
+// is zero), like oauth.Transport's StartRefresher. Call Stop to stop it.
+// StartRefresher does nothing if the refresher is already running.
+func (t *Transport) StartRefresher() {
+	t.refresher.Start(t.ExpiryWindow, func() *oauth.Token {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		return t.OAuthToken
+	}, func(ctx context.Context, stale *oauth.Token) error {
+		_, err := t.renew(ctx, stale)
+		return err
+	})
+}
+
+// Stop stops the refresher started by StartRefresher and waits for it
+// to exit.
+func (t *Transport) Stop() {
+	t.refresher.Stop()
 }
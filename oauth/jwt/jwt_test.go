@@ -8,6 +8,7 @@ package jwt
 
 import (
 	"bytes"
+	"context"
 	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
@@ -15,10 +16,18 @@ import (
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"code.google.com/p/goauth2/oauth"
 )
 
 const (
@@ -411,6 +420,25 @@ func TestHandleResponse(t *testing.T) {
 	}
 }
 
+// An error response should be returned as an *oauth.ErrorResponse.
+func TestHandleResponseError(t *testing.T) {
+	body := `{"error":"invalid_grant","error_description":"Invalid JWT Signature."}`
+	r := &http.Response{
+		Status:     "400 Bad Request",
+		StatusCode: 400,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       ioutil.NopCloser(strings.NewReader(body)),
+	}
+	_, err := handleResponse(r)
+	var e *oauth.ErrorResponse
+	if !errors.As(err, &e) {
+		t.Fatalf("TestHandleResponseError: err = %v, want *oauth.ErrorResponse", err)
+	}
+	if e.Code != "invalid_grant" {
+		t.Errorf("TestHandleResponseError: e.Code = %q, want %q", e.Code, "invalid_grant")
+	}
+}
+
 // passthrough signature for test
 type FakeSigner struct{}
 
@@ -459,6 +487,211 @@ func TestHandleResponseWithNewExpiry(t *testing.T) {
 	}
 }
 
+// AssertContext should give up once its context is done.
+func TestAssertContext(t *testing.T) {
+	tok := NewToken(iss, scope, privateKeyPemBytes)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := tok.AssertContext(ctx, new(http.Client))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("TestAssertContext: err = %v, want %v", err, context.Canceled)
+	}
+}
+
+// The refresher should renew the OAuth token before it expires.
+func TestTransportRefresher(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if g, w := r.FormValue("grant_type"), stdGrantType; g != w {
+			t.Errorf("TestTransportRefresher: grant_type = %q, want %q", g, w)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"access_token":"new","token_type":"Bearer","expires_in":3600}`)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	tok := NewToken(iss, scope, privateKeyPemBytes)
+	tok.ClaimSet.Aud = server.URL
+	tr := &Transport{
+		JWTToken:     tok,
+		OAuthToken:   &oauth.Token{AccessToken: "old", Expiry: time.Now().Add(time.Hour)},
+		ExpiryWindow: time.Hour - 20*time.Millisecond,
+	}
+	tr.StartRefresher()
+	defer tr.Stop()
+	// Wait for the new token to be stored, not just requested: Stop
+	// abandons an assertion in progress.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		tr.mu.Lock()
+		access := tr.OAuthToken.AccessToken
+		tr.mu.Unlock()
+		if access == "new" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("TestTransportRefresher: AccessToken = %q, want %q", access, "new")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// An OAuth token without expires_in has no expiry time to renew it
+// at; the refresher must not assert continuously.
+func TestTransportRefresherNoExpiry(t *testing.T) {
+	var asserts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		asserts.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"access_token":"new","token_type":"Bearer"}`)
+	}))
+	defer server.Close()
+
+	tok := NewToken(iss, scope, privateKeyPemBytes)
+	tok.ClaimSet.Aud = server.URL
+	tr := &Transport{
+		JWTToken:   tok,
+		OAuthToken: &oauth.Token{AccessToken: "old", Expiry: time.Now()},
+	}
+	tr.StartRefresher()
+	time.Sleep(300 * time.Millisecond)
+	tr.Stop()
+	if n := asserts.Load(); n < 1 || n > 8 {
+		t.Errorf("TestTransportRefresherNoExpiry: %d assertions in 300ms, want between 1 and 8", n)
+	}
+}
+
+// Requests must not wait for the refresher while the OAuth token is
+// still valid.
+func TestTransportRefresherNotBlocking(t *testing.T) {
+	// Without an ExpiryWindow the refresher renews the token a minute
+	// before it expires, while requests still use it.
+	release := make(chan bool)
+	started := make(chan bool, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- true
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"access_token":"new","token_type":"Bearer","expires_in":3600}`)
+	}))
+	defer server.Close()
+
+	tok := NewToken(iss, scope, privateKeyPemBytes)
+	tok.ClaimSet.Aud = server.URL
+	tr := &Transport{
+		JWTToken:   tok,
+		OAuthToken: &oauth.Token{AccessToken: "old", Expiry: time.Now().Add(30 * time.Second)},
+	}
+	tr.StartRefresher()
+	<-started
+	got := make(chan *oauth.Token)
+	go func() {
+		o, _ := tr.TokenSource().Token()
+		got <- o
+	}()
+	select {
+	case o := <-got:
+		if o == nil || o.AccessToken != "old" {
+			t.Errorf("TestTransportRefresherNotBlocking: token = %+v, want old", o)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("TestTransportRefresherNotBlocking: Token blocked on the refresher")
+	}
+	close(release)
+	tr.Stop()
+}
+
+// A caller waiting for another caller's assertion gives up when its
+// own context is done, and the assertion is shared by the others.
+func TestTransportRenewWait(t *testing.T) {
+	var asserts atomic.Int32
+	release := make(chan bool)
+	started := make(chan bool, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		asserts.Add(1)
+		started <- true
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"access_token":"new","token_type":"Bearer","expires_in":3600}`)
+	}))
+	defer server.Close()
+
+	tok := NewToken(iss, scope, privateKeyPemBytes)
+	tok.ClaimSet.Aud = server.URL
+	tr := &Transport{
+		JWTToken:   tok,
+		OAuthToken: &oauth.Token{AccessToken: "old", Expiry: time.Now().Add(-time.Minute)},
+	}
+	got := make(chan *oauth.Token, 2)
+	go func() {
+		o, _ := tr.getToken(context.Background())
+		got <- o
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := tr.getToken(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("TestTransportRenewWait: err = %v, want %v", err, context.DeadlineExceeded)
+	}
+	go func() {
+		o, _ := tr.getToken(context.Background())
+		got <- o
+	}()
+	close(release)
+	for i := 0; i < 2; i++ {
+		if o := <-got; o == nil || o.AccessToken != "new" {
+			t.Errorf("TestTransportRenewWait: token = %+v, want new", o)
+		}
+	}
+	if n := asserts.Load(); n != 1 {
+		t.Errorf("TestTransportRenewWait: %d assertions, want 1", n)
+	}
+}
+
+// The TokenSources of a Token and a Transport should reuse a valid
+// OAuth token.
+func TestTokenSource(t *testing.T) {
+	var mu sync.Mutex
+	n := 0
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/secure" {
+			io.WriteString(w, r.Header.Get("Authorization"))
+			return
+		}
+		mu.Lock()
+		n++
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"access_token":"asserted","token_type":"Bearer","expires_in":3600}`)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	tok := NewToken(iss, scope, privateKeyPemBytes)
+	tok.ClaimSet.Aud = server.URL
+	client := oauth.NewClient(tok.TokenSource())
+	for i := 0; i < 2; i++ {
+		r, err := client.Get(server.URL + "/secure")
+		if err != nil {
+			t.Fatalf("TestTokenSource: Get: %v", err)
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if g, w := string(b), "Bearer asserted"; g != w {
+			t.Errorf("TestTokenSource: Authorization = %q, want %q", g, w)
+		}
+	}
+	if n != 1 {
+		t.Errorf("TestTokenSource: asserted %d times, want 1", n)
+	}
+
+	tr := &Transport{JWTToken: tok, OAuthToken: &oauth.Token{AccessToken: "old"}}
+	if o, err := tr.TokenSource().Token(); err != nil || o.AccessToken != "old" {
+		t.Errorf("TestTokenSource: Transport.TokenSource().Token() = %+v, %v, want old", o, err)
+	}
+}
+
 // Placeholder for future Assert tests.
 func TestAssert(t *testing.T) {
 	// Since this method makes a call to BuildRequest, an htttp.Client, and
@@ -0,0 +1,13 @@
+// Copyright 2014 The goauth2 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd) || appengine
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd appengine
+
+package oauth
+
+// syncDir does nothing on systems where a directory cannot be synced.
+func syncDir(dir string) error {
+	return nil
+}
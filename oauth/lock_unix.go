@@ -0,0 +1,56 @@
+// Copyright 2014 The goauth2 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build (darwin || dragonfly || freebsd || linux || netbsd || openbsd) && !appengine
+// +build darwin dragonfly freebsd linux netbsd openbsd
+// +build !appengine
+
+package oauth
+
+import (
+	"os"
+	"syscall"
+)
+
+// LockCache implements CacheLocker using flock(2) on a lock file next to
+// the cache file. The cache file itself cannot be locked because
+// PutToken replaces it.
+func (f CacheFile) LockCache() (unlock func(), err error) {
+	file, err := os.OpenFile(string(f)+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, OAuthError{"CacheFile.LockCache", err.Error()}
+	}
+	for {
+		err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+		if err != syscall.EINTR {
+			break
+		}
+	}
+	if err != nil {
+		file.Close()
+		return nil, OAuthError{"CacheFile.LockCache", err.Error()}
+	}
+	return func() {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		file.Close()
+	}, nil
+}
+
+// syncDir commits the directory entries of dir to disk, so that a file
+// renamed into it survives a crash. File systems that cannot sync a
+// directory report EINVAL, which is ignored.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	if pe, ok := err.(*os.PathError); ok && pe.Err == syscall.EINVAL {
+		err = nil
+	}
+	return err
+}
@@ -0,0 +1,125 @@
+// Copyright 2014 The goauth2 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build (darwin || dragonfly || freebsd || linux || netbsd || openbsd) && !appengine
+// +build darwin dragonfly freebsd linux netbsd openbsd
+// +build !appengine
+
+package oauth
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestCacheFileHelperProcess is run as a separate process by
+// TestCacheFileMultiProcess. It makes a request using the Token in the
+// cache file, refreshing it if necessary.
+func TestCacheFileHelperProcess(t *testing.T) {
+	if os.Getenv("GOAUTH2_WANT_HELPER_PROCESS") != "1" {
+		return
+	}
+	transport := &Transport{Config: &Config{
+		ClientId:   "cl13nt1d",
+		TokenURL:   os.Getenv("GOAUTH2_SERVER") + "/token",
+		TokenCache: CacheFile(os.Getenv("GOAUTH2_CACHE")),
+	}}
+	r, err := transport.Client().Get(os.Getenv("GOAUTH2_SERVER") + "/resource")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	r.Body.Close()
+	if r.StatusCode != 200 {
+		fmt.Fprintln(os.Stderr, "resource:", r.Status)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func TestCacheFileMultiProcess(t *testing.T) {
+	// The server rotates the Refresh Token, rejecting the old one, so
+	// any process that refreshed with a stale Refresh Token would fail.
+	var (
+		mu        sync.Mutex
+		refreshes int
+		refresh   = "refreshtoken0"
+		access    = ""
+	)
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/token":
+			w.Header().Set("Content-Type", "application/json")
+			if r.FormValue("refresh_token") != refresh {
+				w.WriteHeader(http.StatusBadRequest)
+				io.WriteString(w, `{"error":"invalid_grant"}`)
+				return
+			}
+			// Make a concurrent refresh likely if there were no lock.
+			time.Sleep(50 * time.Millisecond)
+			refreshes++
+			refresh = fmt.Sprintf("refreshtoken%d", refreshes)
+			access = fmt.Sprintf("token%d", refreshes)
+			fmt.Fprintf(w, `{"access_token":%q,"refresh_token":%q,"expires_in":3600}`, access, refresh)
+		case "/resource":
+			if r.Header.Get("Authorization") != "Bearer "+access {
+				w.WriteHeader(http.StatusUnauthorized)
+			}
+		}
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	td, err := ioutil.TempDir("", "oauth-test")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %v", err)
+	}
+	defer os.RemoveAll(td)
+	cacheFile := CacheFile(filepath.Join(td, "cache-file"))
+	expired := &Token{AccessToken: "old", RefreshToken: "refreshtoken0", Expiry: time.Now().Add(-time.Hour)}
+	if err := cacheFile.PutToken(expired); err != nil {
+		t.Fatalf("PutToken: %v", err)
+	}
+
+	const n = 5
+	errc := make(chan error, n)
+	for i := 0; i < n; i++ {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestCacheFileHelperProcess$")
+		cmd.Env = append(os.Environ(),
+			"GOAUTH2_WANT_HELPER_PROCESS=1",
+			"GOAUTH2_SERVER="+server.URL,
+			"GOAUTH2_CACHE="+string(cacheFile))
+		go func() {
+			out, err := cmd.CombinedOutput()
+			if err != nil {
+				err = fmt.Errorf("%v: %s", err, out)
+			}
+			errc <- err
+		}()
+	}
+	for i := 0; i < n; i++ {
+		if err := <-errc; err != nil {
+			t.Errorf("helper process: %v", err)
+		}
+	}
+	if refreshes != 1 {
+		t.Errorf("Token refreshed %d times, want 1", refreshes)
+	}
+	tok, err := cacheFile.Token()
+	if err != nil {
+		t.Fatalf("Token: %v", err)
+	}
+	checkToken(t, tok, "token1", "refreshtoken1", "")
+}
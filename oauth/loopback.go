@@ -0,0 +1,140 @@
+// Copyright 2014 The goauth2 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oauth
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// loopbackTimeout is how long ExchangeLoopback waits for the user.
+var loopbackTimeout = 5 * time.Minute
+
+// PrintURL asks the user to visit url by printing it to standard error.
+// It may be passed to ExchangeLoopback.
+func PrintURL(url string) error {
+	_, err := fmt.Fprintf(os.Stderr, "Visit this URL to authorize access:\n\n%s\n\n", url)
+	return err
+}
+
+// ExchangeLoopback obtains a Token for a command-line program using a
+// loopback redirect (RFC 8252). It listens on an ephemeral port of
+// 127.0.0.1, calls open with an authorization URL that redirects there,
+// waits up to five minutes for the user to authorize access, and
+// exchanges the code. The Token is stored in the Transport and written
+// to the TokenCache.
+//
+// open should print the URL, as PrintURL does, or open it in a browser.
+// The Config's RedirectURL is ignored. The state and a PKCE code
+// verifier are generated for the request.
+//
+//	t := &oauth.Transport{Config: config}
+//	if _, err := config.TokenCache.Token(); err != nil {
+//		if _, err := t.ExchangeLoopback(oauth.PrintURL); err != nil {
+//			log.Fatal(err)
+//		}
+//	}
+func (t *Transport) ExchangeLoopback(open func(url string) error, opts ...AuthCodeOption) (*Token, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), loopbackTimeout)
+	defer cancel()
+	return t.ExchangeLoopbackContext(ctx, open, opts...)
+}
+
+// ExchangeLoopbackContext is like ExchangeLoopback but waits for the
+// user until ctx is done, rather than for five minutes.
+func (t *Transport) ExchangeLoopbackContext(ctx context.Context, open func(url string) error, opts ...AuthCodeOption) (*Token, error) {
+	if t.Config == nil {
+		return nil, OAuthError{"ExchangeLoopback", "no Config supplied"}
+	}
+	state, err := NewCodeVerifier()
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := NewCodeVerifier()
+	if err != nil {
+		return nil, err
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	redirectURL := "http://" + ln.Addr().String() + "/"
+	results := make(chan loopbackResult, 1)
+	srv := &http.Server{Handler: loopbackHandler(state, results)}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	// The Config may be shared, so the redirect is added as a parameter
+	// rather than by setting its RedirectURL.
+	opts = append([]AuthCodeOption{
+		Param("redirect_uri", redirectURL),
+		CodeChallengeParams(verifier, ChallengeS256),
+	}, opts...)
+	u, err := t.AuthCodeURLWithOptions(state, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := open(u); err != nil {
+		return nil, err
+	}
+
+	var res loopbackResult
+	select {
+	case res = <-results:
+	case <-ctx.Done():
+		return nil, OAuthError{"ExchangeLoopback", "no authorization received: " + ctx.Err().Error()}
+	}
+	if res.err != nil {
+		return nil, res.err
+	}
+	return t.exchange(ctx, res.code, redirectURL, verifier)
+}
+
+type loopbackResult struct {
+	code string
+	err  error
+}
+
+// loopbackHandler returns a handler for the redirect that sends the
+// first code or error with a matching state to results.
+func loopbackHandler(state string, results chan<- loopbackResult) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		// Ignore requests not made by the authorization server,
+		// which may come from other programs or web pages.
+		if subtle.ConstantTimeCompare([]byte(r.FormValue("state")), []byte(state)) != 1 {
+			http.Error(w, "oauth: state mismatch", http.StatusBadRequest)
+			return
+		}
+		var res loopbackResult
+		if code := r.FormValue("error"); code != "" {
+			res.err = &CallbackError{Code: code, Description: r.FormValue("error_description")}
+		} else if res.code = r.FormValue("code"); res.code == "" {
+			res.err = &CallbackError{msg: "no code"}
+		}
+		select {
+		case results <- res:
+		default:
+			// The flow is already complete.
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if res.err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, "Authorization failed: "+res.err.Error()+"\n")
+			return
+		}
+		io.WriteString(w, "Authorization complete. You may close this window.\n")
+	})
+}
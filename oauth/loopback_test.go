@@ -0,0 +1,115 @@
+// Copyright 2014 The goauth2 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oauth
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// redirect returns an open func for ExchangeLoopback that acts as the
+// user's browser, following the redirect with the given parameters.
+func redirect(t *testing.T, params url.Values) func(string) error {
+	return func(s string) error {
+		u, err := url.Parse(s)
+		if err != nil {
+			return err
+		}
+		q := u.Query()
+		redirectURL := q.Get("redirect_uri")
+		if !strings.HasPrefix(redirectURL, "http://127.0.0.1:") {
+			t.Errorf("redirect_uri = %q, want loopback", redirectURL)
+		}
+		// A request from elsewhere is ignored.
+		r, err := http.Get(redirectURL + "?code=evil&state=wrong")
+		if err != nil {
+			return err
+		}
+		r.Body.Close()
+
+		v := url.Values{"state": {q.Get("state")}}
+		for k, vs := range params {
+			v[k] = vs
+		}
+		r, err = http.Get(redirectURL + "?" + v.Encode())
+		if err != nil {
+			return err
+		}
+		r.Body.Close()
+		return nil
+	}
+}
+
+func TestExchangeLoopback(t *testing.T) {
+	var challenge string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.FormValue("code") != "c0d3" || !strings.HasPrefix(r.FormValue("redirect_uri"), "http://127.0.0.1:") ||
+			s256(r.FormValue("code_verifier")) != challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, `{"error":"invalid_grant"}`)
+			return
+		}
+		io.WriteString(w, `{"access_token":"token1","refresh_token":"refreshtoken1","expires_in":3600}`)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	cache := &memCache{}
+	transport := &Transport{Config: &Config{
+		ClientId:    "cl13nt1d",
+		AuthURL:     server.URL + "/auth",
+		TokenURL:    server.URL + "/token",
+		RedirectURL: "oob",
+		TokenCache:  cache,
+	}}
+	open := redirect(t, url.Values{"code": {"c0d3"}})
+	tok, err := transport.ExchangeLoopback(func(s string) error {
+		u, _ := url.Parse(s)
+		challenge = u.Query().Get("code_challenge")
+		return open(s)
+	})
+	if err != nil {
+		t.Fatalf("ExchangeLoopback: %v", err)
+	}
+	checkToken(t, tok, "token1", "refreshtoken1", "")
+	if transport.Token != tok || cache.tok != tok {
+		t.Errorf("Token was not stored in the Transport and TokenCache")
+	}
+	if g, w := transport.RedirectURL, "oob"; g != w {
+		t.Errorf("RedirectURL = %q after ExchangeLoopback, want %q", g, w)
+	}
+}
+
+func TestExchangeLoopbackErrors(t *testing.T) {
+	transport := &Transport{Config: &Config{
+		ClientId: "cl13nt1d",
+		AuthURL:  "https://example.net/auth",
+		TokenURL: "https://example.net/token",
+	}}
+	_, err := transport.ExchangeLoopback(redirect(t, url.Values{"error": {"access_denied"}}))
+	var ce *CallbackError
+	if !errors.As(err, &ce) || ce.Code != "access_denied" {
+		t.Errorf("ExchangeLoopback error = %v, want access_denied", err)
+	}
+
+	openErr := errors.New("no browser")
+	if _, err := transport.ExchangeLoopback(func(string) error { return openErr }); err != openErr {
+		t.Errorf("ExchangeLoopback error = %v, want %v", err, openErr)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := transport.ExchangeLoopbackContext(ctx, func(string) error { return nil }); err == nil || !strings.Contains(err.Error(), "no authorization received") {
+		t.Errorf("ExchangeLoopbackContext error = %v, want timeout", err)
+	}
+}
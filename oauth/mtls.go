@@ -0,0 +1,181 @@
+// Copyright 2014 The goauth2 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oauth
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// MTLSEndpoints are the endpoints of an authorization server for
+// clients that use mutual TLS. Empty endpoints are the same as without
+// mutual TLS.
+type MTLSEndpoints struct {
+	TokenURL         string `json:"token_endpoint"`
+	DeviceAuthURL    string `json:"device_authorization_endpoint"`
+	RevocationURL    string `json:"revocation_endpoint"`
+	IntrospectionURL string `json:"introspection_endpoint"`
+}
+
+// MTLSConfig is like Config but returns a Config that makes its
+// requests to the server with tlsConfig, which should have a client
+// certificate, using the server's MTLSEndpointAliases. The caller must
+// fill in the ClientId, and the ClientSecret only if the server should
+// not authenticate the client with its certificate.
+//
+//	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+//	config := md.MTLSConfig(tlsConfig)
+//	config.ClientId = YOUR_CLIENT_ID
+//	t := &oauth.Transport{Config: config}
+func (m *Metadata) MTLSConfig(tlsConfig *tls.Config) *Config {
+	c := m.Config()
+	c.TLSClientConfig = tlsConfig
+	if a := m.MTLSEndpointAliases; a != nil {
+		for _, e := range []struct{ alias, url *string }{
+			{&a.TokenURL, &c.TokenURL},
+			{&a.DeviceAuthURL, &c.DeviceAuthURL},
+			{&a.RevocationURL, &c.RevocationURL},
+			{&a.IntrospectionURL, &c.IntrospectionURL},
+		} {
+			if *e.alias != "" {
+				*e.url = *e.alias
+			}
+		}
+	}
+	return c
+}
+
+// CertThumbprint returns the SHA-256 thumbprint of cert, as used in the
+// x5t#S256 confirmation of a certificate-bound token.
+func CertThumbprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// BoundCertThumbprint returns the thumbprint of the certificate the
+// Token is bound to (see CertThumbprint), or "" if that is not known.
+// It is only known for access tokens that are JWTs with an x5t#S256
+// confirmation claim.
+func (t *Token) BoundCertThumbprint() string {
+	return t.Extra["x5t#S256"]
+}
+
+// checkBinding returns the thumbprint of the certificate that the
+// access token is bound to, if any. It is an error for the token to be
+// bound to a certificate other than the TLSClientConfig's.
+func (t *Transport) checkBinding(access string) (string, error) {
+	x5t := tokenThumbprint(access)
+	if x5t == "" || t.TLSClientConfig == nil {
+		return x5t, nil
+	}
+	if want := configThumbprint(t.TLSClientConfig); want != "" && x5t != want {
+		return "", OAuthError{"updateToken", "access token is bound to a different certificate"}
+	}
+	return x5t, nil
+}
+
+// tokenThumbprint returns the x5t#S256 confirmation claim of access if
+// it is a JWT. The JWT's signature is not checked: it is for the
+// resource server to verify.
+func tokenThumbprint(access string) string {
+	parts := strings.Split(access, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return ""
+	}
+	var c struct {
+		Cnf struct {
+			X5t string `json:"x5t#S256"`
+		} `json:"cnf"`
+	}
+	if json.Unmarshal(b, &c) != nil {
+		return ""
+	}
+	return c.Cnf.X5t
+}
+
+// configThumbprint returns the thumbprint of the client certificate in
+// c, or "" if it has none or it is chosen by GetClientCertificate.
+func configThumbprint(c *tls.Config) string {
+	if len(c.Certificates) == 0 || len(c.Certificates[0].Certificate) == 0 {
+		return ""
+	}
+	cert := c.Certificates[0].Leaf
+	if cert == nil {
+		var err error
+		if cert, err = x509.ParseCertificate(c.Certificates[0].Certificate[0]); err != nil {
+			return ""
+		}
+	}
+	return CertThumbprint(cert)
+}
+
+// mtlsTransports holds the copies of HTTP transports made for a
+// Config's TLSClientConfig, one for each HTTP transport copied, so that
+// every Transport using the Config reuses their connections.
+type mtlsTransports struct {
+	mu sync.Mutex
+	m  map[*http.Transport]*mtlsTransport // by the transport copied
+}
+
+// mtlsTransport is a copy of an HTTP transport made for config.
+type mtlsTransport struct {
+	config *tls.Config
+	rt     *http.Transport
+}
+
+// mtlsTransports returns the Config's mtlsTransports, creating them if
+// necessary.
+func (c *Config) mtlsTransports() *mtlsTransports {
+	if m, ok := c.mtls.Load().(*mtlsTransports); ok {
+		return m
+	}
+	c.mtls.CompareAndSwap(nil, new(mtlsTransports))
+	return c.mtls.Load().(*mtlsTransports)
+}
+
+// get returns the copy of base made for config. A copy made for an
+// earlier TLSClientConfig is replaced.
+func (m *mtlsTransports) get(base *http.Transport, config *tls.Config) *http.Transport {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e := m.m[base]; e != nil {
+		if e.config == config {
+			return e.rt
+		}
+		e.rt.CloseIdleConnections()
+	}
+	rt := base.Clone()
+	rt.TLSClientConfig = config
+	if m.m == nil {
+		m.m = make(map[*http.Transport]*mtlsTransport)
+	}
+	m.m[base] = &mtlsTransport{config, rt}
+	return rt
+}
+
+// tlsTransport returns the HTTP transport for the Transport's requests,
+// to the provider and to resource servers: the Transport's, with the
+// TLSClientConfig if it is set. The copy made for the TLSClientConfig
+// is kept with the Config, so that its connections are reused.
+func (t *Transport) tlsTransport() (http.RoundTripper, error) {
+	if t.Config == nil || t.TLSClientConfig == nil {
+		return t.transport(), nil
+	}
+	base, ok := t.transport().(*http.Transport)
+	if !ok {
+		return nil, OAuthError{"TLSClientConfig", "Transport is not an *http.Transport"}
+	}
+	return t.mtlsTransports().get(base, t.TLSClientConfig), nil
+}
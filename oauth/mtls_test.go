@@ -0,0 +1,200 @@
+// Copyright 2014 The goauth2 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oauth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newClientCert returns a self-signed client certificate.
+func newClientCert(t *testing.T, name string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// boundToken returns a JWT access token bound to the certificate with
+// thumbprint x5t. It is not signed.
+func boundToken(x5t string) string {
+	claims := fmt.Sprintf(`{"sub":"cl13nt1d","cnf":{"x5t#S256":%q}}`, x5t)
+	return "eyJhbGciOiJub25lIn0." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".c2ln"
+}
+
+func TestMTLS(t *testing.T) {
+	// The server's /mtls/token endpoint issues tokens bound to the
+	// certificate presented, or to bindTo if it is set.
+	var bindTo string
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			t.Errorf("%s: no client certificate", r.URL.Path)
+			return
+		}
+		x5t := CertThumbprint(r.TLS.PeerCertificates[0])
+		if r.URL.Path == "/resource" {
+			// A bound token is only accepted with its certificate.
+			access := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if tokenThumbprint(access) != x5t {
+				w.WriteHeader(http.StatusUnauthorized)
+			}
+			return
+		}
+		if _, _, ok := r.BasicAuth(); ok {
+			t.Errorf("%s: got Authorization header, want none", r.URL.Path)
+		}
+		r.ParseForm()
+		if s := r.PostForm.Get("client_secret"); s != "" {
+			t.Errorf("%s: client_secret = %q, want none", r.URL.Path, s)
+		}
+		if g, w := r.PostForm.Get("client_id"), "cl13nt1d"; g != w {
+			t.Errorf("%s: client_id = %q, want %q", r.URL.Path, g, w)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/mtls/token":
+			if bindTo != "" {
+				x5t = bindTo
+			}
+			fmt.Fprintf(w, `{"access_token":%q,"expires_in":3600}`, boundToken(x5t))
+		case "/mtls/introspect":
+			fmt.Fprintf(w, `{"active":true,"cnf":{"x5t#S256":%q}}`, x5t)
+		default:
+			t.Errorf("request to %s, want an mTLS endpoint", r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	cert := newClientCert(t, "client")
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}, RootCAs: roots}
+
+	md := &Metadata{
+		TokenURL:         server.URL + "/token",
+		IntrospectionURL: server.URL + "/introspect",
+		MTLSEndpointAliases: &MTLSEndpoints{
+			TokenURL:         server.URL + "/mtls/token",
+			IntrospectionURL: server.URL + "/mtls/introspect",
+		},
+	}
+	config := md.MTLSConfig(tlsConfig)
+	config.ClientId = "cl13nt1d"
+	if g, w := config.TokenURL, server.URL+"/mtls/token"; g != w {
+		t.Errorf("TokenURL = %q, want %q", g, w)
+	}
+
+	tr := &Transport{Config: config}
+	if err := tr.AuthenticateClient(); err != nil {
+		t.Fatalf("AuthenticateClient: %v", err)
+	}
+	// The copy of the HTTP transport is kept with the Config and reused
+	// by every Transport using it, including those made from copies.
+	rt1, _ := tr.tlsTransport()
+	cc := &ClientCredentials{Config: config}
+	for _, other := range []*Transport{tr, {Config: config}, config.UserTransport(&MemoryStore{}, "u"), cc.transport("", nil)} {
+		if rt, _ := other.tlsTransport(); rt != rt1 {
+			t.Errorf("tlsTransport made a new HTTP transport for the same TLSClientConfig")
+		}
+	}
+
+	// Requests to resource servers present the certificate that the
+	// Token is bound to.
+	resp, err := tr.Client().Get(server.URL + "/resource")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("resource request with a bound Token: status %s, want 200 OK", resp.Status)
+	}
+	if g, w := tr.Token.BoundCertThumbprint(), CertThumbprint(leaf); g != w {
+		t.Errorf("BoundCertThumbprint = %q, want %q", g, w)
+	}
+
+	info, err := tr.Introspect(tr.Token.AccessToken, AccessTokenHint)
+	if err != nil {
+		t.Fatalf("Introspect: %v", err)
+	}
+	if g, w := info.CertThumbprint, CertThumbprint(leaf); g != w {
+		t.Errorf("Introspection.CertThumbprint = %q, want %q", g, w)
+	}
+	if info.Extra != nil {
+		t.Errorf("Introspection.Extra = %v, want nil", info.Extra)
+	}
+
+	// A token bound to another certificate is rejected.
+	bindTo = "b0gus"
+	if err := tr.AuthenticateClient(); err == nil {
+		t.Errorf("AuthenticateClient with a token bound to another certificate succeeded, want error")
+	}
+
+	// The TLS configuration can only be added to an *http.Transport.
+	tr = &Transport{Config: config, Transport: &Transport{}}
+	if err := tr.AuthenticateClient(); err == nil {
+		t.Errorf("AuthenticateClient with a Transport that is not an *http.Transport succeeded, want error")
+	}
+}
+
+// A refresh that returns a Token that is not bound clears the binding.
+func TestMTLSRefreshUnbound(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.FormValue("grant_type") == "authorization_code" {
+			fmt.Fprintf(w, `{"access_token":%q,"refresh_token":"r","expires_in":3600}`, boundToken("x5t"))
+			return
+		}
+		io.WriteString(w, `{"access_token":"opaque","expires_in":3600}`)
+	}))
+	defer server.Close()
+
+	tr := &Transport{Config: &Config{ClientId: "cl13nt1d", TokenURL: server.URL}}
+	tok, err := tr.Exchange("c0d3")
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if g, w := tok.BoundCertThumbprint(), "x5t"; g != w {
+		t.Errorf("BoundCertThumbprint after Exchange = %q, want %q", g, w)
+	}
+	if err := tr.Refresh(); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if g := tr.Token.BoundCertThumbprint(); g != "" {
+		t.Errorf("BoundCertThumbprint after Refresh = %q, want none", g)
+	}
+}
@@ -34,9 +34,15 @@
 //		// btw, r.FormValue("state") == "foo"
 //	}
 //
+// A constant state does not protect against cross-site request forgery.
+// WebFlow provides a landing and handler pair that uses a signed,
+// per-user state.
+//
 package oauth
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -46,9 +52,11 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -64,14 +72,103 @@ func (oe OAuthError) Error() string {
 	return "OAuthError: " + oe.prefix + ": " + oe.msg
 }
 
+// ErrorResponse is the error returned when an authorization server
+// responds to a request with an error, as described in section 5.2 of
+// RFC 6749. It is returned for requests to any of the Config's
+// endpoints, not only the TokenURL. Use errors.As to inspect it.
+type ErrorResponse struct {
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int
+
+	// Code is the "error" field of the response, such as
+	// "invalid_grant" or "invalid_client". It is empty if the server
+	// did not send a well-formed error response (for example, a 503
+	// from a proxy).
+	Code string
+
+	// Description and URI are the optional "error_description" and
+	// "error_uri" fields of the response.
+	Description string
+	URI         string
+
+	// Body is the raw response body, truncated to 1MB.
+	Body []byte
+}
+
+func (e *ErrorResponse) Error() string {
+	msg := fmt.Sprintf("oauth: server returned %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Code != "" {
+		msg += ": " + e.Code
+	}
+	if e.Description != "" {
+		msg += ": " + e.Description
+	}
+	return msg
+}
+
+// ParseErrorResponse returns the *ErrorResponse for the response r from
+// an authorization server endpoint, whose body has already been read into
+// body. Like a successful token response, the body may be JSON or
+// form-encoded.
+func ParseErrorResponse(r *http.Response, body []byte) *ErrorResponse {
+	e := &ErrorResponse{
+		StatusCode: r.StatusCode,
+		Body:       body,
+	}
+	content, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch content {
+	case "application/x-www-form-urlencoded", "text/plain":
+		vals, err := url.ParseQuery(string(body))
+		if err != nil {
+			return e
+		}
+		e.Code = vals.Get("error")
+		e.Description = vals.Get("error_description")
+		e.URI = vals.Get("error_uri")
+	default:
+		var b struct {
+			Code        string `json:"error"`
+			Description string `json:"error_description"`
+			URI         string `json:"error_uri"`
+		}
+		if json.Unmarshal(body, &b) == nil {
+			e.Code = b.Code
+			e.Description = b.Description
+			e.URI = b.URI
+		}
+	}
+	return e
+}
+
 // Cache specifies the methods that implement a Token cache.
 type Cache interface {
 	Token() (*Token, error)
 	PutToken(*Token) error
 }
 
+// CacheDeleter may be implemented by a Cache that can remove its Token.
+// Transport.Revoke uses it to clear the cache; for a Cache that does not
+// implement it, an empty Token is stored instead.
+type CacheDeleter interface {
+	DeleteToken() error
+}
+
+// CacheLocker may be implemented by a Cache that is shared between
+// processes. The Transport holds the lock while it reads the cached
+// Token, refreshes it and writes the new Token, so that only one process
+// refreshes a Token and a rotated Refresh Token is never lost.
+type CacheLocker interface {
+	// LockCache blocks until the caller holds an exclusive lock on
+	// the cache, and returns a function that releases it.
+	LockCache() (unlock func(), err error)
+}
+
 // CacheFile implements Cache. Its value is the name of the file in which
 // the Token is stored in JSON format.
+//
+// The file is replaced atomically, so readers never see a partly written
+// Token. On Unix systems CacheFile also implements CacheLocker, using an
+// advisory lock on the file with ".lock" appended to its name.
 type CacheFile string
 
 func (f CacheFile) Token() (*Token, error) {
@@ -88,20 +185,44 @@ func (f CacheFile) Token() (*Token, error) {
 }
 
 func (f CacheFile) PutToken(tok *Token) error {
-	file, err := os.OpenFile(string(f), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	// Write to a temporary file in the same directory and rename it
+	// over the cache file once it is safely on disk.
+	dir, base := filepath.Split(string(f))
+	if dir == "" {
+		dir = "."
+	}
+	file, err := ioutil.TempFile(dir, base+".tmp")
 	if err != nil {
 		return OAuthError{"CacheFile.PutToken", err.Error()}
 	}
-	if err := json.NewEncoder(file).Encode(tok); err != nil {
-		file.Close()
+	err = json.NewEncoder(file).Encode(tok)
+	if err == nil {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), string(f))
+	}
+	if err != nil {
+		os.Remove(file.Name())
 		return OAuthError{"CacheFile.PutToken", err.Error()}
 	}
-	if err := file.Close(); err != nil {
+	// Make the rename itself durable.
+	if err := syncDir(dir); err != nil {
 		return OAuthError{"CacheFile.PutToken", err.Error()}
 	}
 	return nil
 }
 
+func (f CacheFile) DeleteToken() error {
+	if err := os.Remove(string(f)); err != nil && !os.IsNotExist(err) {
+		return OAuthError{"CacheFile.DeleteToken", err.Error()}
+	}
+	return nil
+}
+
 // Config is the configuration of an OAuth consumer.
 type Config struct {
 	// ClientId is the OAuth client identifier used when communicating with
@@ -123,6 +244,18 @@ type Config struct {
 	// TokenURL is the URL used to retrieve OAuth tokens.
 	TokenURL string
 
+	// DeviceAuthURL is the URL used to start the device authorization
+	// grant (see Transport.DeviceAuth). It is optional.
+	DeviceAuthURL string
+
+	// RevocationURL is the URL used to revoke tokens (see
+	// Transport.Revoke). It is optional.
+	RevocationURL string
+
+	// IntrospectionURL is the URL used to query the state of tokens
+	// (see Transport.Introspect). It is optional.
+	IntrospectionURL string
+
 	// RedirectURL is the URL to which the user will be returned after
 	// granting (or denying) access.
 	RedirectURL string
@@ -130,6 +263,44 @@ type Config struct {
 	// TokenCache allows tokens to be cached for subsequent requests.
 	TokenCache Cache
 
+	// TokenNotify, if not nil, is called whenever a Transport obtains a
+	// new Token: by Exchange, AuthenticateClient and the other grants,
+	// and by Refresh, including the automatic refresh of an expired
+	// Token. It is passed the previous Token, which may be nil, and the
+	// new one. When a refresh fails, it is passed the Token that could
+	// not be refreshed, a nil new Token and the error.
+	//
+	// TokenNotify must not modify the Tokens. It may be called by
+	// several goroutines at once, and while the TokenCache is locked.
+	TokenNotify func(old, new *Token, err error)
+
+	// AuthStyle specifies how the client credentials are sent to the
+	// TokenURL and the other endpoints of the provider. The default,
+	// AuthStyleAutoDetect, works out the right style for most providers.
+	AuthStyle AuthStyle
+
+	// ClientAssertion, if not nil, authenticates the client with a
+	// signed JWT (RFC 7523) instead of the ClientSecret, which is not
+	// sent. It is called for each request to the TokenURL and the
+	// other endpoints of the provider, with the TokenURL as the
+	// audience, and must return a new assertion each time. The jwt
+	// package provides implementations for the private_key_jwt and
+	// client_secret_jwt methods.
+	ClientAssertion func(audience string) (string, error)
+
+	// TLSClientConfig, if not nil, is the TLS configuration for the
+	// requests to the TokenURL and the other endpoints of the provider.
+	// With a client certificate, the provider may bind the Tokens it
+	// issues to the certificate (RFC 8705), and if the ClientSecret is
+	// empty the certificate alone authenticates the client. Requests
+	// made with bound Tokens must present the same certificate, so a
+	// Transport also makes its requests to resource servers with the
+	// TLSClientConfig. The Transport's Transport must be nil or an
+	// *http.Transport, which is copied with TLSClientConfig; the copy
+	// is shared by the Transports using the Config.
+	// See Metadata.MTLSConfig.
+	TLSClientConfig *tls.Config
+
 	// AccessType is an OAuth extension that gets sent as the
 	// "access_type" field in the URL from AuthCodeURL.
 	// See https://developers.google.com/accounts/docs/OAuth2WebServer.
@@ -149,6 +320,18 @@ type Config struct {
 	// If set to "force" the user will always be prompted, and the
 	// code can be exchanged for a refresh token.
 	ApprovalPrompt string
+
+	// mtls holds the *mtlsTransports made for the TLSClientConfig. It
+	// is shared with the copies made by clone.
+	mtls atomic.Value
+}
+
+// clone returns a copy of c that shares its HTTP transports for the
+// TLSClientConfig.
+func (c *Config) clone() *Config {
+	c.mtlsTransports()
+	c2 := *c
+	return &c2
 }
 
 // Token contains an end-user's tokens.
@@ -159,21 +342,44 @@ type Token struct {
 	Expiry       time.Time // If zero the token has no (known) expiry time.
 
 	// Extra optionally contains extra metadata from the server
-	// when updating a token. The only current key that may be
-	// populated is "id_token". It may be nil and will be
-	// initialized as needed.
+	// when updating a token. The keys that may be populated are
+	// "id_token", "issued_token_type" for a token exchange, and
+	// "x5t#S256" for a certificate-bound token (see
+	// BoundCertThumbprint). It may be nil and will be initialized as
+	// needed.
 	Extra map[string]string
 }
 
 // Expired reports whether the token has expired or is invalid.
 func (t *Token) Expired() bool {
+	return t.ExpiresWithin(0)
+}
+
+// clone returns a copy of t, or an empty Token if t is nil.
+func (t *Token) clone() *Token {
+	if t == nil {
+		return new(Token)
+	}
+	t2 := *t
+	if t.Extra != nil {
+		t2.Extra = make(map[string]string, len(t.Extra))
+		for k, v := range t.Extra {
+			t2.Extra[k] = v
+		}
+	}
+	return &t2
+}
+
+// ExpiresWithin reports whether the token is invalid or will expire
+// within d.
+func (t *Token) ExpiresWithin(d time.Duration) bool {
 	if t.AccessToken == "" {
 		return true
 	}
 	if t.Expiry.IsZero() {
 		return false
 	}
-	return t.Expiry.Before(time.Now())
+	return t.Expiry.Before(time.Now().Add(d))
 }
 
 // Transport implements http.RoundTripper. When configured with a valid
@@ -184,19 +390,59 @@ func (t *Token) Expired() bool {
 //      // t now contains a valid Token
 //	r, _, err := t.Client().Get("http://example.org/url/requiring/auth")
 //
-// It will automatically refresh the Token if it can,
-// updating the supplied Token in place.
+// It will automatically refresh the Token if it can. A refresh replaces
+// the Token with a new one; the previous Token is never modified. While
+// the Token is valid RoundTrip takes no locks, and concurrent refreshes
+// are collapsed into a single request to the TokenURL.
+//
+// The Token may be set directly before the Transport is first used.
+// After that, use the Transport's methods to change it: a Token assigned
+// directly is only noticed once the previous one has expired.
 type Transport struct {
 	*Config
 	*Token
 
-	// mu guards modifying the token.
+	// mu guards modifying the token and the fields below.
 	mu sync.Mutex
 
+	// cur is the Token used by RoundTrip. It is only stored while
+	// holding mu, but may be loaded without it.
+	cur atomic.Pointer[Token]
+
+	// flight is the refresh in progress, if any.
+	flight *refreshCall
+
 	// Transport is the HTTP transport to use when making requests.
 	// It will default to http.DefaultTransport if nil.
 	// (It should never be an oauth.Transport.)
 	Transport http.RoundTripper
+
+	// ExpiryWindow is how long before its Expiry the Token is
+	// considered expired and refreshed. This keeps tokens from expiring
+	// while a request is in flight. If zero, the Token is refreshed only
+	// once it has expired.
+	ExpiryWindow time.Duration
+
+	// RetryInvalidToken enables a single retry of requests rejected
+	// with a 401 status and a Bearer error of "invalid_token", as happens
+	// when the provider revokes or rotates a token before its Expiry. The
+	// Token is refreshed and the request is sent again; requests with a
+	// body are only retried if they have a GetBody function.
+	RetryInvalidToken bool
+
+	// refresher is the background refresher started by StartRefresher.
+	refresher Refresher
+
+	// clientGrant holds the parameters of the client credentials grant
+	// after AuthenticateClient. Since no Refresh Token is issued, the
+	// Token is renewed by repeating the grant. It is guarded by mu.
+	clientGrant url.Values
+
+
+	// CodeVerifier is the PKCE code verifier that was used to build
+	// the AuthCodeURL (see AuthCodeURLWithVerifier). If non-empty it is
+	// sent as the "code_verifier" parameter by Exchange.
+	CodeVerifier string
 }
 
 // Client returns an *http.Client that makes OAuth-authenticated requests.
@@ -213,12 +459,23 @@ func (t *Transport) transport() http.RoundTripper {
 
 // AuthCodeURL returns a URL that the end-user should be redirected to,
 // so that they may obtain an authorization code.
+// It panics if AuthURL is malformed; see AuthCodeURLWithOptions.
 func (c *Config) AuthCodeURL(state string) string {
+	u, err := c.authCodeURL(state, nil)
+	if err != nil {
+		panic(err)
+	}
+	return u
+}
+
+// authCodeURL builds the AuthCodeURL, adding any extra parameters.
+// Parameters in extra with no values are removed.
+func (c *Config) authCodeURL(state string, extra url.Values) (string, error) {
 	url_, err := url.Parse(c.AuthURL)
 	if err != nil {
-		panic("AuthURL malformed: " + err.Error())
+		return "", OAuthError{"AuthCodeURL", "AuthURL malformed: " + err.Error()}
 	}
-	q := url.Values{
+	v := url.Values{
 		"response_type":   {"code"},
 		"client_id":       {c.ClientId},
 		"state":           condVal(state),
@@ -226,13 +483,21 @@ func (c *Config) AuthCodeURL(state string) string {
 		"redirect_uri":    condVal(c.RedirectURL),
 		"access_type":     condVal(c.AccessType),
 		"approval_prompt": condVal(c.ApprovalPrompt),
-	}.Encode()
+	}
+	for k, vs := range extra {
+		if len(vs) == 0 {
+			delete(v, k)
+			continue
+		}
+		v[k] = vs
+	}
+	q := v.Encode()
 	if url_.RawQuery == "" {
 		url_.RawQuery = q
 	} else {
 		url_.RawQuery += "&" + q
 	}
-	return url_.String()
+	return url_.String(), nil
 }
 
 func condVal(v string) []string {
@@ -244,33 +509,79 @@ func condVal(v string) []string {
 
 // Exchange takes a code and gets access Token from the remote server.
 func (t *Transport) Exchange(code string) (*Token, error) {
+	return t.ExchangeContext(context.Background(), code)
+}
+
+// ExchangeContext is like Exchange but uses ctx for the request to the
+// TokenURL.
+func (t *Transport) ExchangeContext(ctx context.Context, code string) (*Token, error) {
+	return t.exchange(ctx, code, t.RedirectURL, t.CodeVerifier)
+}
+
+// exchange exchanges code, which was issued for redirectURL, using the
+// PKCE verifier if not empty.
+func (t *Transport) exchange(ctx context.Context, code, redirectURL, verifier string) (*Token, error) {
 	if t.Config == nil {
 		return nil, OAuthError{"Exchange", "no Config supplied"}
 	}
 
-	// If the transport or the cache already has a token, it is
-	// passed to `updateToken` to preserve existing refresh token.
+	// If the transport or the cache already has a token, a copy of it
+	// is passed to `updateToken` to preserve existing refresh token.
+	t.mu.Lock()
 	tok := t.Token
+	t.mu.Unlock()
 	if tok == nil && t.TokenCache != nil {
 		tok, _ = t.TokenCache.Token()
 	}
-	if tok == nil {
-		tok = new(Token)
-	}
-	err := t.updateToken(tok, url.Values{
+	tok = tok.clone()
+	v := url.Values{
 		"grant_type":   {"authorization_code"},
-		"redirect_uri": {t.RedirectURL},
+		"redirect_uri": {redirectURL},
 		"scope":        {t.Scope},
 		"code":         {code},
-	})
+	}
+	if verifier != "" {
+		v.Set("code_verifier", verifier)
+	}
+	err := t.updateToken(ctx, tok, v)
 	if err != nil {
 		return nil, err
 	}
-	t.Token = tok
+	return tok, t.putToken(tok)
+}
+
+// putToken makes tok the Transport's Token and writes it to the
+// TokenCache.
+func (t *Transport) putToken(tok *Token) error {
+	return t.putGrantToken(tok, nil)
+}
+
+// putGrantToken is like putToken, but records grant as the client
+// credentials grant that renews tok.
+func (t *Transport) putGrantToken(tok *Token, grant url.Values) error {
+	t.mu.Lock()
+	old := t.Token
+	t.setToken(tok)
+	t.clientGrant = grant
+	t.mu.Unlock()
+	t.notify(old, tok, nil)
 	if t.TokenCache != nil {
-		return tok, t.TokenCache.PutToken(tok)
+		return t.TokenCache.PutToken(tok)
 	}
-	return tok, nil
+	return nil
+}
+
+// notify calls the Config's TokenNotify, if any.
+func (t *Transport) notify(old, tok *Token, err error) {
+	if t.Config != nil && t.TokenNotify != nil {
+		t.TokenNotify(old, tok, err)
+	}
+}
+
+// setToken makes tok the Transport's Token. t.mu must be held.
+func (t *Transport) setToken(tok *Token) {
+	t.Token = tok
+	t.cur.Store(tok)
 }
 
 // RoundTrip executes a single HTTP transaction using the Transport's
@@ -278,52 +589,215 @@ func (t *Transport) Exchange(code string) (*Token, error) {
 //
 // This method will attempt to renew the Token if it has expired and may return
 // an error related to that Token renewal before attempting the client request.
+// The renewal is bound to the request's context.
 // If the Token cannot be renewed a non-nil os.Error value will be returned.
 // If the Token is invalid callers should expect HTTP-level errors,
-// as indicated by the Response's StatusCode.
+// as indicated by the Response's StatusCode, unless RetryInvalidToken
+// is set. If the retry's refresh fails the original response is returned.
 func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
-	accessToken, err := t.getAccessToken()
+	tok, err := t.getToken(req.Context())
 	if err != nil {
 		return nil, err
 	}
+	resp, err := t.send(req, tok)
+	if err != nil || !t.RetryInvalidToken || !invalidToken(resp) {
+		return resp, err
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		// The body has been consumed and cannot be sent again.
+		return resp, nil
+	}
+	newTok, err := t.renew(req.Context(), tok)
+	if err != nil || newTok.AccessToken == "" {
+		return resp, nil
+	}
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return resp, nil
+		}
+		req = cloneRequest(req)
+		req.Body = body
+	}
+	resp.Body.Close()
+	return t.send(req, newTok)
+}
+
+// send makes the HTTP request authorized with tok.
+func (t *Transport) send(req *http.Request, tok *Token) (*http.Response, error) {
 	// To set the Authorization header, we must make a copy of the Request
 	// so that we don't modify the Request we were given.
 	// This is required by the specification of http.RoundTripper.
 	req = cloneRequest(req)
-	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Authorization", "Bearer "+tok.AccessToken)
+
+	// Make the HTTP request, presenting the client certificate that a
+	// bound Token requires.
+	rt, err := t.tlsTransport()
+	if err != nil {
+		return nil, err
+	}
+	return rt.RoundTrip(req)
+}
+
+// invalidToken reports whether resp rejects the request's access token,
+// as described in section 3.1 of RFC 6750.
+func invalidToken(resp *http.Response) bool {
+	if resp.StatusCode != http.StatusUnauthorized {
+		return false
+	}
+	for _, v := range resp.Header.Values("WWW-Authenticate") {
+		scheme, params, _ := strings.Cut(strings.TrimSpace(v), " ")
+		if !strings.EqualFold(scheme, "Bearer") {
+			continue
+		}
+		for _, p := range strings.Split(params, ",") {
+			k, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+			if strings.EqualFold(k, "error") && strings.Trim(v, `"`) == "invalid_token" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// getToken returns a valid Token, refreshing it if necessary.
+func (t *Transport) getToken(ctx context.Context) (*Token, error) {
+	// Fast path: use the current Token without locking.
+	if tok := t.cur.Load(); tok != nil && !tok.ExpiresWithin(t.ExpiryWindow) {
+		return tok, nil
+	}
+
+	tok, err := t.renew(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	if tok.AccessToken == "" {
+		return nil, errors.New("no access token obtained from refresh")
+	}
+	return tok, nil
+}
 
-	// Make the HTTP request.
-	return t.transport().RoundTrip(req)
+// refreshCall is a refresh in progress. Its result is shared by every
+// caller that needed a refresh while it was running.
+type refreshCall struct {
+	done chan struct{} // closed when tok and err are set
+	tok  *Token
+	err  error
 }
 
-func (t *Transport) getAccessToken() (string, error) {
+// renew returns a Token that is fresher than stale. If stale is nil, it
+// returns the current Token if it is valid. Otherwise the Token is
+// refreshed, by this caller or by a refresh already in progress.
+func (t *Transport) renew(ctx context.Context, stale *Token) (*Token, error) {
+	for {
+		t.mu.Lock()
+		cur, err := t.loadToken()
+		if err != nil {
+			t.mu.Unlock()
+			return nil, err
+		}
+		if stale == nil && !cur.ExpiresWithin(t.ExpiryWindow) || stale != nil && cur != stale {
+			t.mu.Unlock()
+			return cur, nil
+		}
+		c := t.flight
+		if c == nil {
+			c = &refreshCall{done: make(chan struct{})}
+			t.flight = c
+			t.mu.Unlock()
+			return t.doRefresh(ctx, c, cur)
+		}
+		t.mu.Unlock()
+
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		// If the refresh was abandoned because its caller went away,
+		// try again on behalf of this one.
+		if (errors.Is(c.err, context.Canceled) || errors.Is(c.err, context.DeadlineExceeded)) && ctx.Err() == nil {
+			stale = cur
+			continue
+		}
+		return c.tok, c.err
+	}
+}
+
+// doRefresh refreshes cur, publishes the result to the waiters on c, and
+// makes the new Token the Transport's Token.
+func (t *Transport) doRefresh(ctx context.Context, c *refreshCall, cur *Token) (*Token, error) {
+	var putErr error
+	c.tok, putErr, c.err = t.refreshCached(ctx, cur)
 	t.mu.Lock()
-	defer t.mu.Unlock()
+	t.flight = nil
+	if c.err == nil {
+		t.setToken(c.tok)
+	}
+	t.mu.Unlock()
+	close(c.done)
+
+	if c.err != nil {
+		return nil, c.err
+	}
+	return c.tok, putErr
+}
 
+// refreshCached refreshes cur and writes the new Token to the
+// TokenCache. If the TokenCache is a CacheLocker it is locked
+// throughout, and a Token written by another process since cur was read
+// is used rather than refreshing again.
+func (t *Transport) refreshCached(ctx context.Context, cur *Token) (tok *Token, putErr, err error) {
+	if t.Config == nil || t.TokenCache == nil {
+		tok, err = t.refreshToken(ctx, cur)
+		t.notify(cur, tok, err)
+		return tok, nil, err
+	}
+	if l, ok := t.TokenCache.(CacheLocker); ok {
+		unlock, err := l.LockCache()
+		if err != nil {
+			return nil, nil, err
+		}
+		defer unlock()
+		if cached, err := t.TokenCache.Token(); err == nil && cached.AccessToken != "" {
+			if cached.AccessToken != cur.AccessToken && !cached.ExpiresWithin(t.ExpiryWindow) {
+				return cached, nil, nil
+			}
+			if cached.RefreshToken != "" {
+				// The Refresh Token may have been rotated.
+				cur = cached
+			}
+		}
+	}
+	tok, err = t.refreshToken(ctx, cur)
+	t.notify(cur, tok, err)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tok, t.TokenCache.PutToken(tok), nil
+}
+
+// loadToken returns the Transport's Token, reading it from the
+// TokenCache if necessary. t.mu must be held.
+func (t *Transport) loadToken() (*Token, error) {
 	if t.Token == nil {
 		if t.Config == nil {
-			return "", OAuthError{"RoundTrip", "no Config supplied"}
+			return nil, OAuthError{"RoundTrip", "no Config supplied"}
 		}
 		if t.TokenCache == nil {
-			return "", OAuthError{"RoundTrip", "no Token supplied"}
+			return nil, OAuthError{"RoundTrip", "no Token supplied"}
 		}
-		var err error
-		t.Token, err = t.TokenCache.Token()
+		tok, err := t.TokenCache.Token()
 		if err != nil {
-			return "", err
+			return nil, err
 		}
+		t.setToken(tok)
+	} else if t.cur.Load() != t.Token {
+		// The Token was assigned directly.
+		t.cur.Store(t.Token)
 	}
-
-	// Refresh the Token if it has expired.
-	if t.Expired() {
-		if err := t.Refresh(); err != nil {
-			return "", err
-		}
-	}
-	if t.AccessToken == "" {
-		return "", errors.New("no access token obtained from refresh")
-	}
-	return t.AccessToken, nil
+	return t.Token, nil
 }
 
 // cloneRequest returns a clone of the provided *http.Request.
@@ -342,98 +816,232 @@ func cloneRequest(r *http.Request) *http.Request {
 
 // Refresh renews the Transport's AccessToken using its RefreshToken.
 func (t *Transport) Refresh() error {
-	if t.Token == nil {
+	return t.RefreshContext(context.Background())
+}
+
+// RefreshContext is like Refresh but uses ctx for the request to the
+// TokenURL.
+func (t *Transport) RefreshContext(ctx context.Context) error {
+	t.mu.Lock()
+	tok := t.Token
+	t.mu.Unlock()
+	if tok == nil {
 		return OAuthError{"Refresh", "no existing Token"}
 	}
-	if t.RefreshToken == "" {
-		return OAuthError{"Refresh", "Token expired; no Refresh Token"}
+	_, err := t.renew(ctx, tok)
+	return err
+}
+
+// refreshToken returns a new Token obtained using the RefreshToken of old,
+// or by repeating the client credentials grant.
+func (t *Transport) refreshToken(ctx context.Context, old *Token) (*Token, error) {
+	t.mu.Lock()
+	grant := t.clientGrant
+	t.mu.Unlock()
+	if grant != nil && old.RefreshToken == "" {
+		return t.clientCredentials(ctx, grant)
+	}
+	if old.RefreshToken == "" {
+		return nil, OAuthError{"Refresh", "Token expired; no Refresh Token"}
 	}
 	if t.Config == nil {
-		return OAuthError{"Refresh", "no Config supplied"}
+		return nil, OAuthError{"Refresh", "no Config supplied"}
 	}
 
-	err := t.updateToken(t.Token, url.Values{
+	tok := old.clone()
+	err := t.updateToken(ctx, tok, url.Values{
 		"grant_type":    {"refresh_token"},
-		"refresh_token": {t.RefreshToken},
+		"refresh_token": {old.RefreshToken},
 	})
 	if err != nil {
-		return err
-	}
-	if t.TokenCache != nil {
-		return t.TokenCache.PutToken(t.Token)
+		return nil, err
 	}
-	return nil
+	return tok, nil
 }
 
 // AuthenticateClient gets an access Token using the client_credentials grant
-// type.
+// type. The Config's Scope is requested if set. When the Token expires,
+// the grant is repeated to renew it.
+//
+// The Token is written to the TokenCache, but the grant is only known to
+// this Transport: a client Token read from the TokenCache by another
+// Transport cannot be renewed, and AuthenticateClient must be called
+// again once it expires.
 func (t *Transport) AuthenticateClient() error {
+	return t.AuthenticateClientContext(context.Background())
+}
+
+// AuthenticateClientContext is like AuthenticateClient but uses ctx for
+// the request to the TokenURL.
+func (t *Transport) AuthenticateClientContext(ctx context.Context) error {
 	if t.Config == nil {
 		return OAuthError{"Exchange", "no Config supplied"}
 	}
-	if t.Token == nil {
-		t.Token = &Token{}
-	}
-	return t.updateToken(t.Token, url.Values{"grant_type": {"client_credentials"}})
-}
-
-// providerAuthHeaderWorks reports whether the OAuth2 server identified by the tokenURL
-// implements the OAuth2 spec correctly
-// See https://code.google.com/p/goauth2/issues/detail?id=31 for background.
-// In summary:
-// - Reddit only accepts client secret in the Authorization header
-// - Dropbox accepts either it in URL param or Auth header, but not both.
-// - Google only accepts URL param (not spec compliant?), not Auth header
-func providerAuthHeaderWorks(tokenURL string) bool {
-	if strings.HasPrefix(tokenURL, "https://accounts.google.com/") ||
-		strings.HasPrefix(tokenURL, "https://github.com/") ||
-		strings.HasPrefix(tokenURL, "https://api.instagram.com/") ||
-		strings.HasPrefix(tokenURL, "https://www.douban.com/") {
-		// Some sites fail to implement the OAuth2 spec fully.
-		return false
+	v := url.Values{"grant_type": {"client_credentials"}}
+	if t.Scope != "" {
+		v.Set("scope", t.Scope)
 	}
+	return t.authenticateClient(ctx, v)
+}
 
-	// Assume the provider implements the spec properly
-	// otherwise. We can add more exceptions as they're
-	// discovered. We will _not_ be adding configurable hooks
-	// to this package to let users select server bugs.
-	return true
+// authenticateClient runs the client credentials grant with the
+// parameters v and makes the result the Transport's Token.
+func (t *Transport) authenticateClient(ctx context.Context, v url.Values) error {
+	tok, err := t.clientCredentials(ctx, v)
+	if err != nil {
+		return err
+	}
+	return t.putGrantToken(tok, v)
 }
 
-// updateToken mutates both tok and v.
-func (t *Transport) updateToken(tok *Token, v url.Values) error {
-	v.Set("client_id", t.ClientId)
-	bustedAuth := !providerAuthHeaderWorks(t.TokenURL)
-	if bustedAuth {
+// clientCredentials returns a new Token obtained with the client
+// credentials grant parameters in grant.
+func (t *Transport) clientCredentials(ctx context.Context, grant url.Values) (*Token, error) {
+	if t.Config == nil {
+		return nil, OAuthError{"Refresh", "no Config supplied"}
+	}
+	// Copy grant, as postForm modifies its argument.
+	v := make(url.Values, len(grant))
+	for k, vs := range grant {
+		v[k] = vs
+	}
+	// Start from an empty Token so that a Refresh Token belonging to
+	// a user is never carried over.
+	tok := new(Token)
+	if err := t.updateToken(ctx, tok, v); err != nil {
+		return nil, err
+	}
+	return tok, nil
+}
+
+// AuthenticatePassword gets an access Token using the password grant type
+// with the resource owner's username and password. The Config's Scope is
+// requested if set. The Token is stored in the Transport and the
+// TokenCache, and is refreshed as usual if a Refresh Token was issued.
+func (t *Transport) AuthenticatePassword(username, password string) error {
+	return t.AuthenticatePasswordContext(context.Background(), username, password)
+}
+
+// AuthenticatePasswordContext is like AuthenticatePassword but uses ctx
+// for the request to the TokenURL.
+func (t *Transport) AuthenticatePasswordContext(ctx context.Context, username, password string) error {
+	if t.Config == nil {
+		return OAuthError{"AuthenticatePassword", "no Config supplied"}
+	}
+	v := url.Values{
+		"grant_type": {"password"},
+		"username":   {username},
+		"password":   {password},
+	}
+	if t.Scope != "" {
+		v.Set("scope", t.Scope)
+	}
+	// Start from an empty Token so that a Refresh Token belonging to
+	// a previous user is never carried over.
+	tok := new(Token)
+	if err := t.updateToken(ctx, tok, v); err != nil {
+		return err
+	}
+	return t.putToken(tok)
+}
+
+// postForm sends v to endpoint, authenticating as the Config's client,
+// and returns the response and its body. A non-200 response is reported
+// as an *ErrorResponse. postForm mutates v.
+func (t *Transport) postForm(ctx context.Context, endpoint string, v url.Values) (*http.Response, []byte, error) {
+	if t.ClientAssertion != nil {
+		assertion, err := t.ClientAssertion(t.TokenURL)
+		if err != nil {
+			return nil, nil, err
+		}
+		v.Set("client_assertion_type", clientAssertionType)
+		v.Set("client_assertion", assertion)
+		return t.doPostForm(ctx, endpoint, v, authStyleClientId)
+	}
+	if t.ClientSecret == "" && t.TLSClientConfig != nil {
+		return t.doPostForm(ctx, endpoint, v, authStyleClientId)
+	}
+	if t.ClientId == "" {
+		// There is no client to authenticate.
+		return t.doPostForm(ctx, endpoint, v, authStyleNone)
+	}
+	style := t.AuthStyle
+	if style == AuthStyleAutoDetect {
+		style = lookupAuthStyle(t.TokenURL)
+	}
+	if style != AuthStyleAutoDetect {
+		return t.doPostForm(ctx, endpoint, v, style)
+	}
+
+	// Try the spec-compliant Authorization header first, then fall back
+	// to form parameters if the server rejects the request.
+	hv := make(url.Values, len(v))
+	for k, vs := range v {
+		hv[k] = vs
+	}
+	r, body, err := t.doPostForm(ctx, endpoint, hv, AuthStyleInHeader)
+	if err == nil {
+		setAuthStyle(t.TokenURL, AuthStyleInHeader)
+	}
+	if !clientAuthFailed(err) {
+		return r, body, err
+	}
+	r, body, err = t.doPostForm(ctx, endpoint, v, AuthStyleInParams)
+	if err == nil {
+		setAuthStyle(t.TokenURL, AuthStyleInParams)
+	}
+	return r, body, err
+}
+
+// doPostForm is postForm using the given AuthStyle.
+func (t *Transport) doPostForm(ctx context.Context, endpoint string, v url.Values, style AuthStyle) (*http.Response, []byte, error) {
+	if style != authStyleNone {
+		v.Set("client_id", t.ClientId)
+	}
+	if style == AuthStyleInParams {
 		v.Set("client_secret", t.ClientSecret)
 	}
-	client := &http.Client{Transport: t.transport()}
-	req, err := http.NewRequest("POST", t.TokenURL, strings.NewReader(v.Encode()))
+	rt, err := t.tlsTransport()
 	if err != nil {
-		return err
+		return nil, nil, err
+	}
+	client := &http.Client{Transport: rt}
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, strings.NewReader(v.Encode()))
+	if err != nil {
+		return nil, nil, err
 	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	if !bustedAuth {
+	if style == AuthStyleInHeader {
 		req.SetBasicAuth(t.ClientId, t.ClientSecret)
 	}
 	r, err := client.Do(req)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 	defer r.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		return nil, nil, err
+	}
 	if r.StatusCode != 200 {
-		return OAuthError{"updateToken", "Unexpected HTTP status " + r.Status}
+		return nil, nil, ParseErrorResponse(r, body)
+	}
+	return r, body, nil
+}
+
+// updateToken mutates both tok and v.
+func (t *Transport) updateToken(ctx context.Context, tok *Token, v url.Values) error {
+	r, body, err := t.postForm(ctx, t.TokenURL, v)
+	if err != nil {
+		return err
 	}
 	var b struct {
 		Access    string `json:"access_token"`
 		Refresh   string `json:"refresh_token"`
 		ExpiresIn int64  `json:"expires_in"` // seconds
 		Id        string `json:"id_token"`
-	}
 
-	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1<<20))
-	if err != nil {
-		return err
+		IssuedTokenType string `json:"issued_token_type"` // RFC 8693
 	}
 
 	content, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
@@ -448,14 +1056,23 @@ func (t *Transport) updateToken(tok *Token, v url.Values) error {
 		b.Refresh = vals.Get("refresh_token")
 		b.ExpiresIn, _ = strconv.ParseInt(vals.Get("expires_in"), 10, 64)
 		b.Id = vals.Get("id_token")
+		b.IssuedTokenType = vals.Get("issued_token_type")
 	default:
 		if err = json.Unmarshal(body, &b); err != nil {
 			return fmt.Errorf("got bad response from server: %q", body)
 		}
 	}
 	if b.Access == "" {
+		// Some providers report errors with a 200 status.
+		if e := ParseErrorResponse(r, body); e.Code != "" {
+			return e
+		}
 		return errors.New("received empty access token from authorization server")
 	}
+	x5t, err := t.checkBinding(b.Access)
+	if err != nil {
+		return err
+	}
 	tok.AccessToken = b.Access
 	// Don't overwrite `RefreshToken` with an empty value
 	if b.Refresh != "" {
@@ -472,5 +1089,20 @@ func (t *Transport) updateToken(tok *Token, v url.Values) error {
 		}
 		tok.Extra["id_token"] = b.Id
 	}
+	if b.IssuedTokenType != "" {
+		if tok.Extra == nil {
+			tok.Extra = make(map[string]string)
+		}
+		tok.Extra["issued_token_type"] = b.IssuedTokenType
+	}
+	if x5t != "" {
+		if tok.Extra == nil {
+			tok.Extra = make(map[string]string)
+		}
+		tok.Extra["x5t#S256"] = x5t
+	} else {
+		// A refreshed Token may no longer be bound.
+		delete(tok.Extra, "x5t#S256")
+	}
 	return nil
 }
@@ -5,6 +5,8 @@
 package oauth
 
 import (
+	"context"
+	"errors"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -13,6 +15,9 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -192,6 +197,106 @@ func checkBody(t *testing.T, r *http.Response, body string) {
 	}
 }
 
+// memCache is a Cache that holds a single Token in memory.
+type memCache struct {
+	tok *Token
+}
+
+func (c *memCache) Token() (*Token, error) {
+	if c.tok == nil {
+		return nil, errors.New("no token")
+	}
+	return c.tok, nil
+}
+
+func (c *memCache) PutToken(tok *Token) error {
+	c.tok = tok
+	return nil
+}
+
+// newCountingServer returns a server that passes each request to
+// handler with its number, counting from 1, and a function that reports
+// how many requests it has received.
+func newCountingServer(handler func(w http.ResponseWriter, r *http.Request, n int)) (*httptest.Server, func() int) {
+	var mu sync.Mutex
+	n := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		n++
+		i := n
+		mu.Unlock()
+		handler(w, r, i)
+	}))
+	count := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return n
+	}
+	return server, count
+}
+
+func TestAuthenticatePassword(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/token":
+			switch r.FormValue("grant_type") {
+			case "password":
+				if r.FormValue("username") != "alice" || r.FormValue("password") != "hunter2" {
+					w.WriteHeader(http.StatusBadRequest)
+					io.WriteString(w, `{"error":"invalid_grant"}`)
+					return
+				}
+				if g, w := r.FormValue("scope"), "https://example.net/scope"; g != w {
+					t.Errorf("scope = %q, want %q", g, w)
+				}
+				io.WriteString(w, `{"access_token":"token1","refresh_token":"refreshtoken1","expires_in":3600}`)
+			case "refresh_token":
+				if g, w := r.FormValue("refresh_token"), "refreshtoken1"; g != w {
+					t.Errorf("refresh_token = %q, want %q", g, w)
+				}
+				io.WriteString(w, `{"access_token":"token2","expires_in":3600}`)
+			default:
+				t.Errorf("unexpected grant_type %q", r.FormValue("grant_type"))
+			}
+		case "/secure":
+			io.WriteString(w, r.Header.Get("Authorization"))
+		}
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	cache := &memCache{}
+	config := &Config{
+		ClientId:   "cl13nt1d",
+		Scope:      "https://example.net/scope",
+		TokenURL:   server.URL + "/token",
+		TokenCache: cache,
+	}
+	transport := &Transport{Config: config}
+	var e *ErrorResponse
+	if err := transport.AuthenticatePassword("alice", "wrong"); !errors.As(err, &e) || e.Code != "invalid_grant" {
+		t.Errorf("AuthenticatePassword with bad password: err = %v, want invalid_grant", err)
+	}
+	if err := transport.AuthenticatePassword("alice", "hunter2"); err != nil {
+		t.Fatalf("AuthenticatePassword: %v", err)
+	}
+	if cache.tok != transport.Token {
+		t.Errorf("Token was not written to the TokenCache")
+	}
+	if g, w := transport.AccessToken, "token1"; g != w {
+		t.Errorf("AccessToken = %q, want %q", g, w)
+	}
+
+	transport.Expiry = time.Now().Add(-time.Hour)
+	resp, err := transport.Client().Get(server.URL + "/secure")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	checkBody(t, resp, "Bearer token2")
+	checkToken(t, cache.tok, "token2", "refreshtoken1", "")
+}
+
 func TestCachePermissions(t *testing.T) {
 	if runtime.GOOS == "windows" {
 		// Windows doesn't support file mode bits.
@@ -218,6 +323,366 @@ func TestCachePermissions(t *testing.T) {
 	}
 }
 
+func TestCacheFileReplace(t *testing.T) {
+	td, err := ioutil.TempDir("", "oauth-test")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %v", err)
+	}
+	defer os.RemoveAll(td)
+	cf := CacheFile(filepath.Join(td, "cache-file"))
+
+	for _, tok := range []*Token{
+		{AccessToken: "a-much-longer-first-token", RefreshToken: "refreshtoken1"},
+		{AccessToken: "token2"},
+	} {
+		if err := cf.PutToken(tok); err != nil {
+			t.Fatalf("PutToken: %v", err)
+		}
+	}
+	tok, err := cf.Token()
+	if err != nil {
+		t.Fatalf("Token: %v", err)
+	}
+	if tok.AccessToken != "token2" || tok.RefreshToken != "" {
+		t.Errorf("cached Token = %+v, want the second Token", tok)
+	}
+	// No temporary files are left behind.
+	names, err := filepath.Glob(filepath.Join(td, "*"))
+	if err != nil || len(names) != 1 {
+		t.Errorf("cache directory holds %q, want only the cache file", names)
+	}
+}
+
+func TestErrorResponse(t *testing.T) {
+	tests := []struct {
+		status      int
+		contenttype string
+		body        string
+		code, desc  string
+	}{
+		{
+			400,
+			"application/json",
+			`{"error":"invalid_grant","error_description":"code expired","error_uri":"https://example.net/e"}`,
+			"invalid_grant", "code expired",
+		},
+		{
+			401,
+			"application/x-www-form-urlencoded",
+			"error=invalid_client&error_description=bad+secret",
+			"invalid_client", "bad secret",
+		},
+		{503, "text/html", "<html>unavailable</html>", "", ""},
+		{200, "application/x-www-form-urlencoded", "error=bad_verification_code", "bad_verification_code", ""},
+	}
+	for _, tt := range tests {
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", tt.contenttype)
+			w.WriteHeader(tt.status)
+			io.WriteString(w, tt.body)
+		}
+		server := httptest.NewServer(http.HandlerFunc(handler))
+		transport := &Transport{Config: &Config{TokenURL: server.URL}}
+		_, err := transport.Exchange("c0d3")
+		server.Close()
+
+		var e *ErrorResponse
+		if !errors.As(err, &e) {
+			t.Errorf("status %d: Exchange error = %v, want *ErrorResponse", tt.status, err)
+			continue
+		}
+		if e.StatusCode != tt.status {
+			t.Errorf("StatusCode = %d, want %d", e.StatusCode, tt.status)
+		}
+		if e.Code != tt.code {
+			t.Errorf("Code = %q, want %q", e.Code, tt.code)
+		}
+		if e.Description != tt.desc {
+			t.Errorf("Description = %q, want %q", e.Description, tt.desc)
+		}
+		if string(e.Body) != tt.body {
+			t.Errorf("Body = %q, want %q", e.Body, tt.body)
+		}
+	}
+}
+
+// The message does not name the endpoint, since it may not be the
+// TokenURL.
+func TestErrorResponseMessage(t *testing.T) {
+	e := &ErrorResponse{StatusCode: 400, Code: "invalid_scope", Description: "unknown scope"}
+	if g, w := e.Error(), "oauth: server returned 400 Bad Request: invalid_scope: unknown scope"; g != w {
+		t.Errorf("Error() = %q, want %q", g, w)
+	}
+}
+
+func TestRoundTripContext(t *testing.T) {
+	// The token endpoint hangs until the test is over.
+	done := make(chan struct{})
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+	defer close(done)
+
+	transport := &Transport{
+		Config: &Config{TokenURL: server.URL + "/token"},
+		Token: &Token{
+			AccessToken:  "token1",
+			RefreshToken: "refreshtoken1",
+			Expiry:       time.Now().Add(-time.Hour),
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"/secure", nil)
+	if _, err := transport.RoundTrip(req); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("RoundTrip error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if _, err := transport.ExchangeContext(ctx, "c0d3"); !errors.Is(err, context.Canceled) {
+		t.Errorf("ExchangeContext error = %v, want %v", err, context.Canceled)
+	}
+	if err := transport.AuthenticateClientContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("AuthenticateClientContext error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestConcurrentRefresh(t *testing.T) {
+	var mu sync.Mutex
+	refreshes := 0
+	release := make(chan struct{})
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			mu.Lock()
+			refreshes++
+			mu.Unlock()
+			<-release
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"access_token":"token2","expires_in":3600}`)
+		case "/secure":
+			io.WriteString(w, r.Header.Get("Authorization"))
+		}
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	transport := &Transport{
+		Config: &Config{TokenURL: server.URL + "/token"},
+		Token: &Token{
+			AccessToken:  "token1",
+			RefreshToken: "refreshtoken1",
+			Expiry:       time.Now().Add(time.Hour),
+		},
+	}
+	c := transport.Client()
+
+	// Requests with a valid Token do not wait for a refresh in progress.
+	refreshed := make(chan error)
+	go func() { refreshed <- transport.Refresh() }()
+	for {
+		mu.Lock()
+		n := refreshes
+		mu.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	resp, err := c.Get(server.URL + "/secure")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	checkBody(t, resp, "Bearer token1")
+
+	// Requests with an expired Token share the refresh in progress.
+	transport.Expiry = time.Now().Add(-time.Hour)
+	const n = 10
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := c.Get(server.URL + "/secure")
+			if err != nil {
+				t.Errorf("Get: %v", err)
+				return
+			}
+			checkBody(t, resp, "Bearer token2")
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if err := <-refreshed; err != nil {
+		t.Errorf("Refresh: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if refreshes != 1 {
+		t.Errorf("refreshes = %d, want 1", refreshes)
+	}
+}
+
+func TestRetryInvalidToken(t *testing.T) {
+	var secure, refreshes int
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			refreshes++
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"access_token":"token2","expires_in":3600}`)
+		case "/secure":
+			secure++
+			auth := r.Header.Get("Authorization")
+			if auth != "Bearer token2" || r.URL.Query().Get("always") != "" {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="example", error="invalid_token", error_description="The access token expired"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			b, _ := ioutil.ReadAll(r.Body)
+			io.WriteString(w, auth+" "+string(b))
+		}
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	newTransport := func() *Transport {
+		return &Transport{
+			Config: &Config{TokenURL: server.URL + "/token"},
+			Token: &Token{
+				AccessToken:  "token1",
+				RefreshToken: "refreshtoken1",
+				Expiry:       time.Now().Add(time.Hour),
+			},
+			RetryInvalidToken: true,
+		}
+	}
+
+	// The request is replayed, with its body, using a new Token.
+	transport := newTransport()
+	resp, err := transport.Client().Post(server.URL+"/secure", "text/plain", strings.NewReader("payload"))
+	if err != nil {
+		t.Fatalf("Post: %v", err)
+	}
+	checkBody(t, resp, "Bearer token2 payload")
+	if secure != 2 || refreshes != 1 {
+		t.Errorf("got %d requests and %d refreshes, want 2 and 1", secure, refreshes)
+	}
+
+	// A request is retried at most once.
+	secure, refreshes = 0, 0
+	transport = newTransport()
+	resp, err = transport.Client().Get(server.URL + "/secure?always=1")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("StatusCode = %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+	if secure != 2 || refreshes != 1 {
+		t.Errorf("got %d requests and %d refreshes, want 2 and 1", secure, refreshes)
+	}
+
+	// Without RetryInvalidToken the 401 is returned.
+	secure, refreshes = 0, 0
+	transport = newTransport()
+	transport.RetryInvalidToken = false
+	resp, err = transport.Client().Get(server.URL + "/secure")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized || secure != 1 || refreshes != 0 {
+		t.Errorf("got status %d, %d requests and %d refreshes, want 401, 1 and 0", resp.StatusCode, secure, refreshes)
+	}
+}
+
+func TestInvalidToken(t *testing.T) {
+	tests := []struct {
+		status int
+		header string
+		want   bool
+	}{
+		{401, `Bearer error="invalid_token"`, true},
+		{401, `bearer realm="example",error=invalid_token`, true},
+		{401, `Bearer realm="example"`, false},
+		{401, `Bearer error="insufficient_scope"`, false},
+		{401, `Basic realm="example"`, false},
+		{403, `Bearer error="invalid_token"`, false},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{
+			StatusCode: tt.status,
+			Header:     http.Header{"Www-Authenticate": {tt.header}},
+		}
+		if got := invalidToken(resp); got != tt.want {
+			t.Errorf("invalidToken(%d, %s) = %v, want %v", tt.status, tt.header, got, tt.want)
+		}
+	}
+}
+
+// roundTripFunc is an http.RoundTripper implemented by a function.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// benchmarkRoundTrip measures RoundTrip throughput with many concurrent
+// callers, for tokens that are valid for lifetime. Refreshes take 1ms.
+func benchmarkRoundTrip(b *testing.B, lifetime time.Duration) {
+	var refreshes int64
+	base := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		resp := &http.Response{
+			StatusCode: 200,
+			Header:     http.Header{"Content-Type": {"application/json"}},
+			Body:       ioutil.NopCloser(strings.NewReader("")),
+		}
+		if req.URL.Path == "/token" {
+			atomic.AddInt64(&refreshes, 1)
+			time.Sleep(time.Millisecond)
+			resp.Body = ioutil.NopCloser(strings.NewReader(`{"access_token":"token2","expires_in":3600}`))
+		}
+		return resp, nil
+	})
+	transport := &Transport{
+		Config: &Config{TokenURL: "http://example.net/token"},
+		Token: &Token{
+			AccessToken:  "token1",
+			RefreshToken: "refreshtoken1",
+			Expiry:       time.Now().Add(time.Hour),
+		},
+		Transport: base,
+		// Tokens are due for refresh lifetime after they are issued.
+		ExpiryWindow: time.Hour - lifetime,
+	}
+	req, _ := http.NewRequest("GET", "http://example.net/secure", nil)
+	b.ResetTimer()
+	b.SetParallelism(8)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := transport.RoundTrip(req); err != nil {
+				b.Fatalf("RoundTrip: %v", err)
+			}
+		}
+	})
+	b.ReportMetric(float64(atomic.LoadInt64(&refreshes))/float64(b.N), "refreshes/op")
+}
+
+func BenchmarkRoundTripValidToken(b *testing.B) {
+	benchmarkRoundTrip(b, time.Hour)
+}
+
+func BenchmarkRoundTripFrequentRefresh(b *testing.B) {
+	benchmarkRoundTrip(b, 5*time.Millisecond)
+}
+
 func TestTokenExpired(t *testing.T) {
 	tests := []struct {
 		token   Token
@@ -234,3 +699,73 @@ func TestTokenExpired(t *testing.T) {
 		}
 	}
 }
+
+func TestTokenNotify(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.FormValue("grant_type") {
+		case "authorization_code":
+			io.WriteString(w, `{"access_token":"token1","refresh_token":"refreshtoken1","expires_in":3600}`)
+		case "refresh_token":
+			if r.FormValue("refresh_token") == "revoked" {
+				w.WriteHeader(http.StatusBadRequest)
+				io.WriteString(w, `{"error":"invalid_grant"}`)
+				return
+			}
+			io.WriteString(w, `{"access_token":"token2","expires_in":3600}`)
+		case "client_credentials":
+			io.WriteString(w, `{"access_token":"token3","expires_in":3600}`)
+		}
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	type event struct {
+		old, new string
+		err      bool
+	}
+	var events []event
+	name := func(tok *Token) string {
+		if tok == nil {
+			return "<nil>"
+		}
+		return tok.AccessToken
+	}
+	config := &Config{
+		ClientId:   "cl13nt1d",
+		TokenURL:   server.URL,
+		TokenCache: &memCache{},
+		TokenNotify: func(old, new *Token, err error) {
+			events = append(events, event{name(old), name(new), err != nil})
+		},
+	}
+	transport := &Transport{Config: config}
+	if _, err := transport.Exchange("c0d3"); err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if err := transport.Refresh(); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if err := transport.AuthenticateClient(); err != nil {
+		t.Fatalf("AuthenticateClient: %v", err)
+	}
+	transport.Token = &Token{AccessToken: "stale", RefreshToken: "revoked", Expiry: time.Now().Add(-time.Hour)}
+	if err := transport.Refresh(); err == nil {
+		t.Fatalf("Refresh with revoked Refresh Token succeeded")
+	}
+
+	want := []event{
+		{"<nil>", "token1", false},
+		{"token1", "token2", false},
+		{"token2", "token3", false},
+		{"stale", "<nil>", true},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("events = %v, want %v", events, want)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Errorf("event %d = %v, want %v", i, events[i], want[i])
+		}
+	}
+}
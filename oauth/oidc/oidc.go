@@ -0,0 +1,424 @@
+// Copyright 2014 The goauth2 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package oidc verifies OpenID Connect ID tokens, such as the id_token
+// stored in oauth.Token.Extra after an Exchange.
+//
+// Example usage:
+//
+//	config, md, err := oauth.Discover("https://accounts.google.com")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	config.ClientId = YOUR_CLIENT_ID
+//	verifier := oidc.NewVerifier(md, config.ClientId)
+//
+//	// After the user has been redirected back to the handler:
+//	tok, err := t.Exchange(r.FormValue("code"))
+//	if err != nil {
+//		...
+//	}
+//	claims, err := verifier.VerifyToken(tok, nonce)
+//	if err != nil {
+//		...
+//	}
+//	// claims.Subject identifies the user.
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"code.google.com/p/goauth2/oauth"
+)
+
+// minRefetch is the minimum time between fetches of the key set made
+// to find an unknown key. It is a variable for testing.
+var minRefetch = time.Minute
+
+// defaultLeeway is used in place of a zero Verifier.Leeway.
+const defaultLeeway = time.Minute
+
+// Verifier verifies ID tokens issued to a client by a provider.
+type Verifier struct {
+	// Issuer is the provider's issuer identifier. It must match the
+	// "iss" claim exactly.
+	Issuer string
+
+	// ClientId is the client's identifier. It must be one of the
+	// token's audiences.
+	ClientId string
+
+	// JWKSURL is the URL of the provider's JSON Web Key Set, which
+	// holds the keys used to sign ID tokens.
+	JWKSURL string
+
+	// Leeway is the clock skew allowed when checking the "exp" and
+	// "iat" claims. If zero, one minute is allowed.
+	Leeway time.Duration
+
+	// Client is used to fetch the key set.
+	// It will default to http.DefaultClient if nil.
+	Client *http.Client
+
+	// mu guards the fields below.
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey // by key ID
+	fetched time.Time
+}
+
+// NewVerifier returns a Verifier for the ID tokens issued to clientId by
+// the provider described by md.
+func NewVerifier(md *oauth.Metadata, clientId string) *Verifier {
+	return &Verifier{
+		Issuer:   md.Issuer,
+		ClientId: clientId,
+		JWKSURL:  md.JWKSURL,
+	}
+}
+
+// Claims are the verified claims of an ID token.
+type Claims struct {
+	Issuer   string
+	Subject  string
+	Audience []string
+	Expiry   time.Time
+	IssuedAt time.Time
+
+	// Nonce is the value passed in the authentication request.
+	Nonce string
+
+	// AuthorizedParty is the "azp" claim, the client the token was
+	// issued to. It may be empty if there is a single audience.
+	AuthorizedParty string
+
+	// Raw holds all of the token's claims, including those above.
+	Raw map[string]interface{}
+}
+
+// Unmarshal decodes the token's claims into v, which should be a
+// pointer to a struct with json tags for the claims of interest, such
+// as "email".
+func (c *Claims) Unmarshal(v interface{}) error {
+	b, err := json.Marshal(c.Raw)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// VerifyToken verifies the "id_token" stored in tok.Extra.
+// See Verify.
+func (v *Verifier) VerifyToken(tok *oauth.Token, nonce string) (*Claims, error) {
+	raw := tok.Extra["id_token"]
+	if raw == "" {
+		return nil, errors.New("oidc: no id_token in Token")
+	}
+	return v.Verify(raw, nonce)
+}
+
+// Verify checks the signature of the raw ID token against the
+// provider's key set, and checks that it was issued by the Issuer to the
+// ClientId and has not expired. If nonce is not empty the token's nonce
+// must match it. RS256 and ES256 signatures are supported.
+func (v *Verifier) Verify(raw, nonce string) (*Claims, error) {
+	return v.VerifyContext(context.Background(), raw, nonce)
+}
+
+// VerifyContext is like Verify but uses ctx when fetching the key set.
+func (v *Verifier) VerifyContext(ctx context.Context, raw, nonce string) (*Claims, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("oidc: malformed ID token")
+	}
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyId     string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("oidc: malformed ID token header: %v", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("oidc: malformed ID token signature: %v", err)
+	}
+	key, err := v.key(ctx, header.KeyId)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Algorithm, key, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+
+	c, err := parseClaims(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	if err := v.check(c, nonce, time.Now()); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// check validates the claims of a token with a valid signature.
+func (v *Verifier) check(c *Claims, nonce string, now time.Time) error {
+	leeway := v.Leeway
+	if leeway == 0 {
+		leeway = defaultLeeway
+	}
+	if c.Issuer != v.Issuer {
+		return fmt.Errorf("oidc: ID token issued by %q, want %q", c.Issuer, v.Issuer)
+	}
+	found := false
+	for _, aud := range c.Audience {
+		if aud == v.ClientId {
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("oidc: ID token audience %q does not include %q", c.Audience, v.ClientId)
+	}
+	if (len(c.Audience) > 1 || c.AuthorizedParty != "") && c.AuthorizedParty != v.ClientId {
+		return fmt.Errorf("oidc: ID token authorized party is %q, want %q", c.AuthorizedParty, v.ClientId)
+	}
+	if c.Expiry.IsZero() || now.Add(-leeway).After(c.Expiry) {
+		return fmt.Errorf("oidc: ID token expired at %v", c.Expiry)
+	}
+	if c.IssuedAt.After(now.Add(leeway)) {
+		return fmt.Errorf("oidc: ID token issued in the future at %v", c.IssuedAt)
+	}
+	if nonce != "" && c.Nonce != nonce {
+		return errors.New("oidc: ID token nonce does not match")
+	}
+	return nil
+}
+
+// parseClaims decodes the claims segment of a token.
+func parseClaims(seg string) (*Claims, error) {
+	var b struct {
+		Issuer          string          `json:"iss"`
+		Subject         string          `json:"sub"`
+		Audience        json.RawMessage `json:"aud"`
+		Expiry          int64           `json:"exp"`
+		IssuedAt        int64           `json:"iat"`
+		Nonce           string          `json:"nonce"`
+		AuthorizedParty string          `json:"azp"`
+	}
+	c := new(Claims)
+	if err := decodeSegment(seg, &b); err != nil {
+		return nil, fmt.Errorf("oidc: malformed ID token claims: %v", err)
+	}
+	if err := decodeSegment(seg, &c.Raw); err != nil {
+		return nil, fmt.Errorf("oidc: malformed ID token claims: %v", err)
+	}
+	c.Issuer = b.Issuer
+	c.Subject = b.Subject
+	c.Nonce = b.Nonce
+	c.AuthorizedParty = b.AuthorizedParty
+	if b.Expiry != 0 {
+		c.Expiry = time.Unix(b.Expiry, 0)
+	}
+	if b.IssuedAt != 0 {
+		c.IssuedAt = time.Unix(b.IssuedAt, 0)
+	}
+	// The audience may be a single string or an array of strings.
+	var aud string
+	if json.Unmarshal(b.Audience, &aud) == nil {
+		c.Audience = []string{aud}
+	} else if json.Unmarshal(b.Audience, &c.Audience) != nil {
+		return nil, fmt.Errorf("oidc: malformed ID token audience %s", b.Audience)
+	}
+	return c, nil
+}
+
+// decodeSegment decodes a base64url encoded JSON token segment into v.
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// verifySignature checks that sig is a valid signature of signed by key
+// using the algorithm alg.
+func verifySignature(alg string, key crypto.PublicKey, signed string, sig []byte) error {
+	h := sha256.Sum256([]byte(signed))
+	switch alg {
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("oidc: RS256 ID token signed with a non-RSA key")
+		}
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, h[:], sig); err != nil {
+			return errors.New("oidc: invalid ID token signature")
+		}
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || pub.Curve != elliptic.P256() {
+			return errors.New("oidc: ES256 ID token signed with a non-P-256 key")
+		}
+		if len(sig) != 64 {
+			return errors.New("oidc: invalid ID token signature")
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(pub, h[:], r, s) {
+			return errors.New("oidc: invalid ID token signature")
+		}
+	default:
+		return fmt.Errorf("oidc: unsupported ID token signing algorithm %q", alg)
+	}
+	return nil
+}
+
+// key returns the provider's key with the given ID. The key set is
+// fetched again if the key is not known, since the provider may have
+// rotated its keys.
+func (v *Verifier) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if key, ok := v.lookup(kid); ok {
+		return key, nil
+	}
+	if !v.fetched.IsZero() && time.Since(v.fetched) < minRefetch {
+		return nil, fmt.Errorf("oidc: unknown ID token signing key %q", kid)
+	}
+	keys, err := v.fetchKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	v.keys, v.fetched = keys, time.Now()
+	if key, ok := v.lookup(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("oidc: unknown ID token signing key %q", kid)
+}
+
+// lookup returns the key with the given ID. If kid is empty and there
+// is a single key, that key is returned. v.mu must be held.
+func (v *Verifier) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(v.keys) == 1 {
+		for _, key := range v.keys {
+			return key, true
+		}
+	}
+	key, ok := v.keys[kid]
+	return key, ok
+}
+
+// jsonWebKey is a member of a JSON Web Key Set (RFC 7517).
+type jsonWebKey struct {
+	Type  string `json:"kty"`
+	Use   string `json:"use"`
+	KeyId string `json:"kid"`
+	N     string `json:"n"`
+	E     string `json:"e"`
+	Curve string `json:"crv"`
+	X     string `json:"x"`
+	Y     string `json:"y"`
+}
+
+// fetchKeys fetches the signing keys from the JWKSURL.
+func (v *Verifier) fetchKeys(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	if v.JWKSURL == "" {
+		return nil, errors.New("oidc: no JWKSURL supplied")
+	}
+	client := v.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", v.JWKSURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	r, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if r.StatusCode != 200 {
+		return nil, fmt.Errorf("oidc: fetching key set: unexpected HTTP status %s", r.Status)
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(body, &set); err != nil {
+		return nil, fmt.Errorf("oidc: malformed key set: %v", err)
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			// Skip keys of unsupported types.
+			continue
+		}
+		keys[k.KeyId] = key
+	}
+	return keys, nil
+}
+
+// publicKey returns the public key described by k.
+func (k *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Type {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		exp := new(big.Int).SetBytes(e)
+		if !exp.IsInt64() || exp.Int64() > 1<<31-1 {
+			return nil, errors.New("oidc: RSA exponent too large")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}, nil
+	case "EC":
+		if k.Curve != "P-256" {
+			return nil, fmt.Errorf("oidc: unsupported curve %q", k.Curve)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("oidc: EC key is not on its curve")
+		}
+		return pub, nil
+	}
+	return nil, fmt.Errorf("oidc: unsupported key type %q", k.Type)
+}
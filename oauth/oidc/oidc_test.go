@@ -0,0 +1,232 @@
+// Copyright 2014 The goauth2 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"code.google.com/p/goauth2/oauth"
+)
+
+var (
+	rsaKey, _ = rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _  = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+)
+
+func b64(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+// keyServer serves a JWKS holding the keys in jwks.
+type keyServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	jwks    []map[string]string
+	fetches int
+}
+
+func newKeyServer(t *testing.T) *keyServer {
+	s := new(keyServer)
+	s.addRSA("rsa1", &rsaKey.PublicKey)
+	s.jwks = append(s.jwks, map[string]string{
+		"kty": "EC", "use": "sig", "kid": "ec1", "crv": "P-256",
+		"x": b64(ecKey.X.FillBytes(make([]byte, 32))),
+		"y": b64(ecKey.Y.FillBytes(make([]byte, 32))),
+	})
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.fetches++
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": s.jwks})
+	}))
+	return s
+}
+
+func (s *keyServer) addRSA(kid string, pub *rsa.PublicKey) {
+	s.jwks = append(s.jwks, map[string]string{
+		"kty": "RSA", "use": "sig", "kid": kid,
+		"n": b64(pub.N.Bytes()),
+		"e": b64(big.NewInt(int64(pub.E)).Bytes()),
+	})
+}
+
+func (s *keyServer) numFetches() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fetches
+}
+
+// sign returns an ID token with the given claims signed by key.
+func sign(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := b64(header) + "." + b64(payload)
+	h := sha256.Sum256([]byte(signed))
+	var sig []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, h[:])
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k, h[:])
+		if err == nil {
+			sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + b64(sig)
+}
+
+func validClaims() map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss":   "https://issuer.example.net",
+		"sub":   "1234",
+		"aud":   "cl13nt1d",
+		"exp":   now.Add(time.Hour).Unix(),
+		"iat":   now.Unix(),
+		"nonce": "n0nc3",
+		"email": "user@example.net",
+	}
+}
+
+func newVerifier(s *keyServer) *Verifier {
+	return NewVerifier(&oauth.Metadata{
+		Issuer:  "https://issuer.example.net",
+		JWKSURL: s.URL,
+	}, "cl13nt1d")
+}
+
+func TestVerify(t *testing.T) {
+	s := newKeyServer(t)
+	defer s.Close()
+	v := newVerifier(s)
+
+	for _, tt := range []struct {
+		alg, kid string
+		key      crypto.Signer
+	}{
+		{"RS256", "rsa1", rsaKey},
+		{"ES256", "ec1", ecKey},
+	} {
+		raw := sign(t, tt.alg, tt.kid, tt.key, validClaims())
+		c, err := v.VerifyToken(&oauth.Token{Extra: map[string]string{"id_token": raw}}, "n0nc3")
+		if err != nil {
+			t.Errorf("%s: Verify: %v", tt.alg, err)
+			continue
+		}
+		if g, w := c.Subject, "1234"; g != w {
+			t.Errorf("%s: Subject = %q, want %q", tt.alg, g, w)
+		}
+		if len(c.Audience) != 1 || c.Audience[0] != "cl13nt1d" {
+			t.Errorf("%s: Audience = %q, want [cl13nt1d]", tt.alg, c.Audience)
+		}
+		var extra struct {
+			Email string `json:"email"`
+		}
+		if err := c.Unmarshal(&extra); err != nil || extra.Email != "user@example.net" {
+			t.Errorf("%s: Unmarshal = %q, %v, want %q", tt.alg, extra.Email, err, "user@example.net")
+		}
+	}
+	if n := s.numFetches(); n != 1 {
+		t.Errorf("key set fetched %d times, want 1", n)
+	}
+}
+
+func TestVerifyErrors(t *testing.T) {
+	s := newKeyServer(t)
+	defer s.Close()
+	v := newVerifier(s)
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	tests := []struct {
+		name   string
+		modify func(map[string]interface{})
+		alg    string
+		key    crypto.Signer
+		nonce  string
+		want   string
+	}{
+		{name: "issuer", modify: func(c map[string]interface{}) { c["iss"] = "https://evil.example.net" }, want: "issued by"},
+		{name: "audience", modify: func(c map[string]interface{}) { c["aud"] = "other" }, want: "audience"},
+		{name: "azp", modify: func(c map[string]interface{}) { c["aud"] = []string{"cl13nt1d", "other"} }, want: "authorized party"},
+		{name: "azp mismatch", modify: func(c map[string]interface{}) { c["azp"] = "other" }, want: "authorized party"},
+		{name: "expired", modify: func(c map[string]interface{}) { c["exp"] = time.Now().Add(-2 * time.Minute).Unix() }, want: "expired"},
+		{name: "future", modify: func(c map[string]interface{}) { c["iat"] = time.Now().Add(2 * time.Minute).Unix() }, want: "future"},
+		{name: "nonce", nonce: "other", want: "nonce"},
+		{name: "signature", key: otherKey, want: "invalid ID token signature"},
+		{name: "algorithm", alg: "HS256", want: "unsupported"},
+	}
+	for _, tt := range tests {
+		claims := validClaims()
+		if tt.modify != nil {
+			tt.modify(claims)
+		}
+		alg, key := "RS256", crypto.Signer(rsaKey)
+		if tt.alg != "" {
+			alg = tt.alg
+		}
+		if tt.key != nil {
+			key = tt.key
+		}
+		_, err := v.Verify(sign(t, alg, "rsa1", key, claims), tt.nonce)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: Verify error = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+
+	// Expiry within the leeway is accepted.
+	claims := validClaims()
+	claims["exp"] = time.Now().Add(-30 * time.Second).Unix()
+	if _, err := v.Verify(sign(t, "RS256", "rsa1", rsaKey, claims), ""); err != nil {
+		t.Errorf("Verify within leeway: %v", err)
+	}
+	if _, err := v.VerifyToken(&oauth.Token{}, ""); err == nil {
+		t.Errorf("VerifyToken without id_token succeeded")
+	}
+}
+
+func TestVerifyKeyRotation(t *testing.T) {
+	defer func(d time.Duration) { minRefetch = d }(minRefetch)
+	minRefetch = 0
+
+	s := newKeyServer(t)
+	defer s.Close()
+	v := newVerifier(s)
+	if _, err := v.Verify(sign(t, "RS256", "rsa1", rsaKey, validClaims()), ""); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	newKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	s.mu.Lock()
+	s.addRSA("rsa2", &newKey.PublicKey)
+	s.mu.Unlock()
+	if _, err := v.Verify(sign(t, "RS256", "rsa2", newKey, validClaims()), ""); err != nil {
+		t.Fatalf("Verify with rotated key: %v", err)
+	}
+	if n := s.numFetches(); n != 2 {
+		t.Errorf("key set fetched %d times, want 2", n)
+	}
+	if _, err := v.Verify(sign(t, "RS256", "unknown", newKey, validClaims()), ""); err == nil {
+		t.Errorf("Verify with unknown key succeeded")
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2014 The goauth2 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oauth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/url"
+)
+
+// Code challenge methods for Proof Key for Code Exchange (PKCE).
+// See http://tools.ietf.org/html/rfc7636.
+const (
+	ChallengeS256  = "S256"
+	ChallengePlain = "plain"
+)
+
+// NewCodeVerifier returns a new random PKCE code verifier. The
+// verifier should be kept by the client for the duration of a single
+// authorization and passed to Exchange via Transport.CodeVerifier.
+func NewCodeVerifier() (string, error) {
+	// 32 octets encode to a 43 character verifier, as recommended
+	// by section 4.1 of the RFC.
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CodeChallenge derives the code_challenge for verifier using method,
+// which must be ChallengeS256 or ChallengePlain. If method is empty,
+// ChallengeS256 is used.
+func CodeChallenge(verifier, method string) (string, error) {
+	switch method {
+	case "", ChallengeS256:
+		sum := sha256.Sum256([]byte(verifier))
+		return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+	case ChallengePlain:
+		return verifier, nil
+	}
+	return "", OAuthError{"CodeChallenge", "unsupported code challenge method: " + method}
+}
+
+// AuthCodeURLWithVerifier is like AuthCodeURL but also sends the
+// code_challenge and code_challenge_method derived from verifier.
+// The same verifier must be supplied when the code is exchanged. It
+// returns an error if method is not supported.
+func (c *Config) AuthCodeURLWithVerifier(state, verifier, method string) (string, error) {
+	return c.AuthCodeURLWithOptions(state, CodeChallengeParams(verifier, method))
+}
+
+// CodeChallengeParams returns an AuthCodeOption that sends the
+// code_challenge and code_challenge_method derived from verifier.
+// See AuthCodeURLWithVerifier.
+func CodeChallengeParams(verifier, method string) AuthCodeOption {
+	if method == "" {
+		method = ChallengeS256
+	}
+	return func(v url.Values) error {
+		challenge, err := CodeChallenge(verifier, method)
+		if err != nil {
+			return err
+		}
+		v.Set("code_challenge", challenge)
+		v.Set("code_challenge_method", method)
+		return nil
+	}
+}
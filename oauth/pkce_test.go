@@ -0,0 +1,110 @@
+// Copyright 2014 The goauth2 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oauth
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestCodeChallenge(t *testing.T) {
+	// Example from Appendix B of RFC 7636.
+	const verifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	for _, tt := range []struct {
+		method, want string
+	}{
+		{ChallengeS256, "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"},
+		{"", "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"},
+		{ChallengePlain, verifier},
+	} {
+		if g, err := CodeChallenge(verifier, tt.method); err != nil || g != tt.want {
+			t.Errorf("CodeChallenge(%q) = %q, %v, want %q", tt.method, g, err, tt.want)
+		}
+	}
+	if _, err := CodeChallenge(verifier, "S512"); err == nil {
+		t.Errorf("CodeChallenge with an unsupported method succeeded, want error")
+	}
+	config := &Config{AuthURL: "https://example.net/auth"}
+	if _, err := config.AuthCodeURLWithVerifier("state", verifier, "S512"); err == nil {
+		t.Errorf("AuthCodeURLWithVerifier with an unsupported method succeeded, want error")
+	}
+}
+
+func TestNewCodeVerifier(t *testing.T) {
+	v1, err := NewCodeVerifier()
+	if err != nil {
+		t.Fatalf("NewCodeVerifier: %v", err)
+	}
+	v2, err := NewCodeVerifier()
+	if err != nil {
+		t.Fatalf("NewCodeVerifier: %v", err)
+	}
+	if len(v1) < 43 || len(v1) > 128 {
+		t.Errorf("verifier length = %d, want between 43 and 128", len(v1))
+	}
+	if v1 == v2 {
+		t.Errorf("NewCodeVerifier returned %q twice", v1)
+	}
+}
+
+func TestPKCEExchange(t *testing.T) {
+	for _, method := range []string{ChallengeS256, ChallengePlain} {
+		var challenge string
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			if got, _ := CodeChallenge(r.FormValue("code_verifier"), method); got != challenge {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				io.WriteString(w, `{"error":"invalid_grant"}`)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"access_token":"token1","expires_in":3600}`)
+		}
+		server := httptest.NewServer(http.HandlerFunc(handler))
+		defer server.Close()
+
+		config := &Config{
+			ClientId: "cl13nt1d",
+			AuthURL:  server.URL + "/auth",
+			TokenURL: server.URL + "/token",
+		}
+		verifier, err := NewCodeVerifier()
+		if err != nil {
+			t.Fatalf("NewCodeVerifier: %v", err)
+		}
+		authURL, err := config.AuthCodeURLWithVerifier("state", verifier, method)
+		if err != nil {
+			t.Fatalf("AuthCodeURLWithVerifier: %v", err)
+		}
+		u, err := url.Parse(authURL)
+		if err != nil {
+			t.Fatalf("parsing AuthCodeURL: %v", err)
+		}
+		q := u.Query()
+		if g, w := q.Get("code_challenge_method"), method; g != w {
+			t.Errorf("code_challenge_method = %q, want %q", g, w)
+		}
+		challenge = q.Get("code_challenge")
+
+		transport := &Transport{Config: config, CodeVerifier: verifier}
+		if _, err := transport.Exchange("c0d3"); err != nil {
+			t.Errorf("%s: Exchange with matching verifier: %v", method, err)
+		}
+
+		transport = &Transport{Config: config, CodeVerifier: verifier + "x"}
+		if _, err := transport.Exchange("c0d3"); err == nil {
+			t.Errorf("%s: Exchange with mismatched verifier succeeded", method)
+		}
+	}
+}
+
+// s256 returns the S256 code challenge for verifier.
+func s256(verifier string) string {
+	challenge, _ := CodeChallenge(verifier, ChallengeS256)
+	return challenge
+}
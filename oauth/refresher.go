@@ -0,0 +1,137 @@
+// Copyright 2014 The goauth2 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oauth
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// These control the background refresher. They are variables for testing.
+var (
+	// refresherWindow is used in place of a zero ExpiryWindow.
+	refresherWindow = time.Minute
+
+	// refresherIdle is how often the refresher checks a Token that has
+	// no known expiry time.
+	refresherIdle = time.Minute
+
+	// refresherRetry is how long the refresher waits after a failed
+	// refresh, or one that returned a Token already due for refresh,
+	// before trying again.
+	refresherRetry = 10 * time.Second
+)
+
+// StartRefresher starts a goroutine that refreshes the Transport's Token
+// ExpiryWindow before it expires (or one minute before, if ExpiryWindow
+// is zero), so that requests rarely have to wait for a refresh. Failed
+// refreshes are retried; RoundTrip still refreshes the Token itself if
+// the refresher falls behind.
+//
+// Call Stop to stop the refresher. StartRefresher does nothing if the
+// refresher is already running.
+func (t *Transport) StartRefresher() {
+	t.refresher.Start(t.ExpiryWindow, func() *Token {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		return t.Token
+	}, func(ctx context.Context, stale *Token) error {
+		_, err := t.renew(ctx, stale)
+		return err
+	})
+}
+
+// Stop stops the refresher started by StartRefresher and waits for it
+// to exit, abandoning any refresh in progress.
+func (t *Transport) Stop() {
+	t.refresher.Stop()
+}
+
+// A Refresher renews a Token in the background shortly before it
+// expires. It runs the refresher of Transport.StartRefresher, and can
+// run one for other transports that hold a Token. The zero Refresher is
+// stopped.
+type Refresher struct {
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Start starts a goroutine that calls renew with the Token returned by
+// current once it expires within window (or one minute, if window is
+// zero). A Token with no known expiry time is checked every minute.
+// Failed renewals, and renewals that leave a Token already due for
+// renewal, are retried after a pause.
+//
+// renew should replace the Token unless it is no longer stale, and give
+// up once ctx is done. Start does nothing if the Refresher is already
+// running.
+func (r *Refresher) Start(window time.Duration, current func() *Token, renew func(ctx context.Context, stale *Token) error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cancel != nil {
+		return
+	}
+	if window <= 0 {
+		window = refresherWindow
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	r.done = make(chan struct{})
+	go refreshLoop(ctx, r.done, window, current, renew)
+}
+
+// Stop stops the goroutine started by Start and waits for it to exit,
+// abandoning any renewal in progress.
+func (r *Refresher) Stop() {
+	r.mu.Lock()
+	cancel, done := r.cancel, r.done
+	r.cancel, r.done = nil, nil
+	r.mu.Unlock()
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+func refreshLoop(ctx context.Context, done chan struct{}, window time.Duration, current func() *Token, renew func(context.Context, *Token) error) {
+	defer close(done)
+	var err error
+	tried := false
+	for {
+		wait := nextRefresh(current(), window)
+		if tried && (err != nil || wait <= 0) && wait < refresherRetry {
+			wait = refresherRetry
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		tok := current()
+		err, tried = nil, false
+		if tok != nil && tok.ExpiresWithin(window) {
+			err = renew(ctx, tok)
+			tried = true
+		}
+	}
+}
+
+// nextRefresh returns how long the refresher should wait before
+// renewing tok.
+func nextRefresh(tok *Token, window time.Duration) time.Duration {
+	if tok == nil || tok.Expiry.IsZero() {
+		return refresherIdle
+	}
+	d := tok.Expiry.Sub(time.Now()) - window
+	if d < 0 {
+		return 0
+	}
+	return d
+}
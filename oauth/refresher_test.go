@@ -0,0 +1,126 @@
+// Copyright 2014 The goauth2 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oauth
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestExpiryWindow(t *testing.T) {
+	var refreshes atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			n := refreshes.Add(1)
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"access_token":"token%d","expires_in":3600}`, n+1)
+		case "/secure":
+			io.WriteString(w, r.Header.Get("Authorization"))
+		}
+	}))
+	defer server.Close()
+
+	transport := &Transport{
+		Config: &Config{TokenURL: server.URL + "/token"},
+		Token: &Token{
+			AccessToken:  "token1",
+			RefreshToken: "refreshtoken1",
+			Expiry:       time.Now().Add(30 * time.Second),
+		},
+	}
+	resp, err := transport.Client().Get(server.URL + "/secure")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	checkBody(t, resp, "Bearer token1")
+
+	transport.ExpiryWindow = time.Minute
+	resp, err = transport.Client().Get(server.URL + "/secure")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	checkBody(t, resp, "Bearer token2")
+	if g, w := refreshes.Load(), int32(1); g != w {
+		t.Errorf("refreshes = %d, want %d", g, w)
+	}
+}
+
+func TestRefresher(t *testing.T) {
+	var refreshes atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if g, w := r.FormValue("grant_type"), "refresh_token"; g != w {
+			t.Errorf("grant_type = %q, want %q", g, w)
+		}
+		n := refreshes.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"token%d","expires_in":3600}`, n+1)
+	}))
+	defer server.Close()
+
+	// Every token is due for renewal 20ms after it is issued.
+	const window = time.Hour - 20*time.Millisecond
+	transport := &Transport{
+		Config: &Config{TokenURL: server.URL + "/token"},
+		Token: &Token{
+			AccessToken:  "token1",
+			RefreshToken: "refreshtoken1",
+			Expiry:       time.Now().Add(time.Hour),
+		},
+		ExpiryWindow: window,
+	}
+	transport.StartRefresher()
+	transport.StartRefresher() // no-op
+
+	deadline := time.Now().Add(5 * time.Second)
+	for refreshes.Load() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("refresher did not renew the token")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	transport.Stop()
+	n := refreshes.Load()
+	time.Sleep(50 * time.Millisecond)
+	if g := refreshes.Load(); g != n {
+		t.Errorf("refreshes after Stop = %d, want %d", g, n)
+	}
+	if transport.AccessToken == "token1" {
+		t.Errorf("AccessToken was not renewed")
+	}
+	transport.Stop() // no-op
+}
+
+// A Token issued already inside the window must not make the refresher
+// refresh continuously.
+func TestRefresherShortLifetime(t *testing.T) {
+	defer func(d time.Duration) { refresherRetry = d }(refresherRetry)
+	refresherRetry = 50 * time.Millisecond
+
+	var refreshes atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		refreshes.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"access_token":"short","expires_in":30}`)
+	}))
+	defer server.Close()
+
+	transport := &Transport{
+		Config:       &Config{TokenURL: server.URL + "/token"},
+		Token:        &Token{AccessToken: "token1", RefreshToken: "r", Expiry: time.Now().Add(30 * time.Second)},
+		ExpiryWindow: time.Minute,
+	}
+	transport.StartRefresher()
+	time.Sleep(300 * time.Millisecond)
+	transport.Stop()
+	if n := refreshes.Load(); n < 1 || n > 8 {
+		t.Errorf("refreshes in 300ms = %d, want between 1 and 8", n)
+	}
+}
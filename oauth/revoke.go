@@ -0,0 +1,86 @@
+// Copyright 2014 The goauth2 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oauth
+
+import (
+	"context"
+	"net/url"
+)
+
+// Token type hints, as used by Revoke and Introspect.
+const (
+	AccessTokenHint  = "access_token"
+	RefreshTokenHint = "refresh_token"
+)
+
+// Revoke revokes the Transport's Token at the Config's RevocationURL,
+// as described in RFC 7009. hint selects the token to revoke: the
+// RefreshToken for RefreshTokenHint or the AccessToken for
+// AccessTokenHint. If hint is empty the RefreshToken is revoked if there
+// is one, and the AccessToken otherwise. Most providers invalidate the
+// access tokens issued from a refresh token when it is revoked.
+//
+// On success the Transport's Token is cleared and removed from the
+// TokenCache.
+func (t *Transport) Revoke(hint string) error {
+	return t.RevokeContext(context.Background(), hint)
+}
+
+// RevokeContext is like Revoke but uses ctx for the request to the
+// RevocationURL.
+func (t *Transport) RevokeContext(ctx context.Context, hint string) error {
+	if t.Config == nil {
+		return OAuthError{"Revoke", "no Config supplied"}
+	}
+	if t.RevocationURL == "" {
+		return OAuthError{"Revoke", "no RevocationURL supplied"}
+	}
+	t.mu.Lock()
+	tok := t.Token
+	t.mu.Unlock()
+	if tok == nil && t.TokenCache != nil {
+		tok, _ = t.TokenCache.Token()
+	}
+	if tok == nil {
+		return OAuthError{"Revoke", "no existing Token"}
+	}
+
+	if hint == "" {
+		hint = AccessTokenHint
+		if tok.RefreshToken != "" {
+			hint = RefreshTokenHint
+		}
+	}
+	var token string
+	switch hint {
+	case AccessTokenHint:
+		token = tok.AccessToken
+	case RefreshTokenHint:
+		token = tok.RefreshToken
+	default:
+		return OAuthError{"Revoke", "unknown token type hint " + hint}
+	}
+	if token == "" {
+		return OAuthError{"Revoke", "no " + hint + " to revoke"}
+	}
+
+	_, _, err := t.postForm(ctx, t.RevocationURL, url.Values{
+		"token":           {token},
+		"token_type_hint": {hint},
+	})
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	t.setToken(nil)
+	t.mu.Unlock()
+	if t.TokenCache == nil {
+		return nil
+	}
+	if d, ok := t.TokenCache.(CacheDeleter); ok {
+		return d.DeleteToken()
+	}
+	return t.TokenCache.PutToken(new(Token))
+}
@@ -0,0 +1,199 @@
+// Copyright 2014 The goauth2 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oauth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrTokenNotFound is returned by TokenStore.Get if there is no Token
+// stored under the key.
+var ErrTokenNotFound = errors.New("oauth: token not found")
+
+// TokenStore stores the Tokens of many users, each under its own key.
+// See StoreKey.
+type TokenStore interface {
+	// Get returns the Token stored under key, or ErrTokenNotFound.
+	Get(key string) (*Token, error)
+
+	// Put stores tok under key.
+	Put(key string, tok *Token) error
+
+	// Delete removes the Token stored under key, if any.
+	Delete(key string) error
+}
+
+// StoreLocker may be implemented by a TokenStore whose Tokens are
+// refreshed by several Transports at once, such as the Transports
+// returned for the same user by UserTransport. The Cache returned by
+// StoreCache is then a CacheLocker that locks the Token's key, so that
+// only one Transport refreshes the Token and the others use the result.
+type StoreLocker interface {
+	// LockKey blocks until the caller holds an exclusive lock on key,
+	// and returns a function that releases it.
+	LockKey(key string) (unlock func(), err error)
+}
+
+// StoreKey returns the key under which the Token of user for scope is
+// stored. The order of the values in scope does not matter.
+func StoreKey(user, scope string) string {
+	scopes := strings.Fields(scope)
+	sort.Strings(scopes)
+	return url.QueryEscape(user) + " " + strings.Join(scopes, " ")
+}
+
+// StoreCache returns a Cache for the Token stored under key.
+func StoreCache(store TokenStore, key string) Cache {
+	if d, ok := store.(DirStore); ok {
+		// Keep the atomic writes and locking of CacheFile.
+		return d.file(key)
+	}
+	return storeCache{store, key}
+}
+
+type storeCache struct {
+	store TokenStore
+	key   string
+}
+
+func (c storeCache) Token() (*Token, error)    { return c.store.Get(c.key) }
+func (c storeCache) PutToken(tok *Token) error { return c.store.Put(c.key, tok) }
+func (c storeCache) DeleteToken() error        { return c.store.Delete(c.key) }
+
+func (c storeCache) LockCache() (unlock func(), err error) {
+	if l, ok := c.store.(StoreLocker); ok {
+		return l.LockKey(c.key)
+	}
+	return func() {}, nil
+}
+
+// UserTransport returns a Transport for the Token of user, which is
+// read from and written to store under StoreKey(user, c.Scope). The
+// Transport uses a copy of c whose TokenCache is replaced; c itself is
+// not modified.
+//
+//	// In the handler for the RedirectURL:
+//	t := config.UserTransport(store, userID)
+//	if _, err := t.Exchange(r.FormValue("code")); err != nil {
+//		...
+//	}
+//
+//	// Later, for the same user:
+//	client := config.UserClient(store, userID)
+//	client.Get(...)
+func (c *Config) UserTransport(store TokenStore, user string) *Transport {
+	c2 := c.clone()
+	c2.TokenCache = StoreCache(store, StoreKey(user, c.Scope))
+	return &Transport{Config: c2}
+}
+
+// UserClient returns an *http.Client that makes requests with the Token
+// of user. See UserTransport.
+func (c *Config) UserClient(store TokenStore, user string) *http.Client {
+	return c.UserTransport(store, user).Client()
+}
+
+// MemoryStore is a TokenStore that keeps Tokens in memory. It is also
+// a StoreLocker. The zero value is an empty store ready to use.
+type MemoryStore struct {
+	mu    sync.Mutex
+	m     map[string]*Token
+	locks map[string]*keyLock
+}
+
+// keyLock is the lock on a key of a MemoryStore, and the number of
+// callers holding or waiting for it.
+type keyLock struct {
+	mu sync.Mutex
+	n  int
+}
+
+func (s *MemoryStore) Get(key string) (*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tok, ok := s.m[key]
+	if !ok {
+		return nil, ErrTokenNotFound
+	}
+	return tok.clone(), nil
+}
+
+func (s *MemoryStore) Put(key string, tok *Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.m == nil {
+		s.m = make(map[string]*Token)
+	}
+	s.m[key] = tok.clone()
+	return nil
+}
+
+func (s *MemoryStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.m, key)
+	return nil
+}
+
+func (s *MemoryStore) LockKey(key string) (unlock func(), err error) {
+	s.mu.Lock()
+	l := s.locks[key]
+	if l == nil {
+		if s.locks == nil {
+			s.locks = make(map[string]*keyLock)
+		}
+		l = new(keyLock)
+		s.locks[key] = l
+	}
+	l.n++
+	s.mu.Unlock()
+
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+		s.mu.Lock()
+		// Forget the lock once nobody holds it.
+		if l.n--; l.n == 0 {
+			delete(s.locks, key)
+		}
+		s.mu.Unlock()
+	}, nil
+}
+
+// DirStore is a TokenStore that keeps each Token in a file in the named
+// directory, which must exist. The files are written like CacheFile's.
+type DirStore string
+
+// file returns the CacheFile for key. The key is hashed so that any key
+// makes a valid file name.
+func (d DirStore) file(key string) CacheFile {
+	sum := sha256.Sum256([]byte(key))
+	return CacheFile(filepath.Join(string(d), hex.EncodeToString(sum[:])+".json"))
+}
+
+func (d DirStore) Get(key string) (*Token, error) {
+	f := d.file(key)
+	if _, err := os.Stat(string(f)); os.IsNotExist(err) {
+		return nil, ErrTokenNotFound
+	}
+	return f.Token()
+}
+
+func (d DirStore) Put(key string, tok *Token) error {
+	return d.file(key).PutToken(tok)
+}
+
+func (d DirStore) Delete(key string) error {
+	return d.file(key).DeleteToken()
+}
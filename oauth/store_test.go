@@ -0,0 +1,162 @@
+// Copyright 2014 The goauth2 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oauth
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTokenStores(t *testing.T) {
+	td, err := ioutil.TempDir("", "oauth-test")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %v", err)
+	}
+	defer os.RemoveAll(td)
+
+	for _, store := range []TokenStore{new(MemoryStore), DirStore(td)} {
+		if _, err := store.Get("alice"); err != ErrTokenNotFound {
+			t.Errorf("%T: Get of missing key = %v, want ErrTokenNotFound", store, err)
+		}
+		tok := &Token{AccessToken: "token1", Extra: map[string]string{"id_token": "id"}}
+		if err := store.Put("alice", tok); err != nil {
+			t.Fatalf("%T: Put: %v", store, err)
+		}
+		if err := store.Put("bob/../x", &Token{AccessToken: "token2"}); err != nil {
+			t.Fatalf("%T: Put: %v", store, err)
+		}
+		tok.Extra["id_token"] = "changed"
+		got, err := store.Get("alice")
+		if err != nil {
+			t.Fatalf("%T: Get: %v", store, err)
+		}
+		if got.AccessToken != "token1" || got.Extra["id_token"] != "id" {
+			t.Errorf("%T: Get = %+v, want token1 with id_token id", store, got)
+		}
+		if got, err := store.Get("bob/../x"); err != nil || got.AccessToken != "token2" {
+			t.Errorf("%T: Get = %+v, %v, want token2", store, got, err)
+		}
+		if err := store.Delete("alice"); err != nil {
+			t.Errorf("%T: Delete: %v", store, err)
+		}
+		if _, err := store.Get("alice"); err != ErrTokenNotFound {
+			t.Errorf("%T: Get after Delete = %v, want ErrTokenNotFound", store, err)
+		}
+		if err := store.Delete("alice"); err != nil {
+			t.Errorf("%T: second Delete: %v", store, err)
+		}
+	}
+}
+
+func TestStoreKey(t *testing.T) {
+	if g, w := StoreKey("alice", "b a"), StoreKey("alice", "a  b"); g != w {
+		t.Errorf("StoreKey depends on scope order: %q != %q", g, w)
+	}
+	if StoreKey("a b", "c") == StoreKey("a", "b c") {
+		t.Errorf("StoreKey is ambiguous")
+	}
+}
+
+func TestUserTransport(t *testing.T) {
+	// Each user's Refresh Token is exchanged for a new access token.
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"access-%s","expires_in":3600}`, r.FormValue("refresh_token"))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	config := &Config{ClientId: "cl13nt1d", Scope: "email", TokenURL: server.URL}
+	store := new(MemoryStore)
+	expired := time.Now().Add(-time.Hour)
+	for _, user := range []string{"alice", "bob"} {
+		store.Put(StoreKey(user, "email"), &Token{AccessToken: "old", RefreshToken: user, Expiry: expired})
+	}
+	for _, user := range []string{"alice", "bob"} {
+		tr := config.UserTransport(store, user)
+		tok, err := tr.getToken(context.Background())
+		if err != nil {
+			t.Fatalf("%s: getToken: %v", user, err)
+		}
+		if g, w := tok.AccessToken, "access-"+user; g != w {
+			t.Errorf("%s: AccessToken = %q, want %q", user, g, w)
+		}
+		stored, err := store.Get(StoreKey(user, "email"))
+		if err != nil || stored.AccessToken != "access-"+user {
+			t.Errorf("%s: stored Token = %+v, %v, want refreshed Token", user, stored, err)
+		}
+	}
+	if config.TokenCache != nil {
+		t.Errorf("UserTransport modified the shared Config")
+	}
+}
+
+// Concurrent Transports for the same user must not refresh the Token in
+// parallel: the Refresh Token is rotated, so all but one would fail.
+func TestUserTransportConcurrent(t *testing.T) {
+	var mu sync.Mutex
+	current := map[string]string{"alice": "alice0", "bob": "bob0"}
+	refreshes := make(map[string]int)
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		user := strings.TrimRight(r.FormValue("refresh_token"), "0123456789")
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if r.FormValue("refresh_token") != current[user] {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, `{"error":"invalid_grant"}`)
+			return
+		}
+		refreshes[user]++
+		current[user] = fmt.Sprintf("%s%d", user, refreshes[user])
+		fmt.Fprintf(w, `{"access_token":"access-%s","refresh_token":%q,"expires_in":3600}`, current[user], current[user])
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	config := &Config{ClientId: "cl13nt1d", Scope: "email", TokenURL: server.URL}
+	store := new(MemoryStore)
+	expired := time.Now().Add(-time.Hour)
+	users := []string{"alice", "bob"}
+	for _, user := range users {
+		store.Put(StoreKey(user, "email"), &Token{AccessToken: "old", RefreshToken: user + "0", Expiry: expired})
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		for _, user := range users {
+			wg.Add(1)
+			go func(user string) {
+				defer wg.Done()
+				tok, err := config.UserTransport(store, user).getToken(context.Background())
+				if err != nil {
+					t.Errorf("%s: getToken: %v", user, err)
+					return
+				}
+				if g, w := tok.AccessToken, "access-"+user+"1"; g != w {
+					t.Errorf("%s: AccessToken = %q, want %q", user, g, w)
+				}
+			}(user)
+		}
+	}
+	wg.Wait()
+	for _, user := range users {
+		if n := refreshes[user]; n != 1 {
+			t.Errorf("%s: refreshed %d times, want 1", user, n)
+		}
+	}
+	if len(store.locks) != 0 {
+		t.Errorf("MemoryStore holds %d locks after use, want 0", len(store.locks))
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2014 The goauth2 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oauth
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+const tokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+
+// Token type identifiers for token exchange.
+// See http://tools.ietf.org/html/rfc8693#section-3.
+const (
+	TokenTypeAccessToken  = "urn:ietf:params:oauth:token-type:access_token"
+	TokenTypeRefreshToken = "urn:ietf:params:oauth:token-type:refresh_token"
+	TokenTypeIDToken      = "urn:ietf:params:oauth:token-type:id_token"
+	TokenTypeJWT          = "urn:ietf:params:oauth:token-type:jwt"
+	TokenTypeSAML1        = "urn:ietf:params:oauth:token-type:saml1"
+	TokenTypeSAML2        = "urn:ietf:params:oauth:token-type:saml2"
+)
+
+// TokenExchangeRequest describes a token exchange (RFC 8693): a request
+// for a Token to act on behalf of the subject of SubjectToken, or, if
+// ActorToken is set, for the actor to act for the subject.
+type TokenExchangeRequest struct {
+	SubjectToken     string
+	SubjectTokenType string // such as TokenTypeAccessToken
+
+	// ActorToken and ActorTokenType identify the party that will act
+	// for the subject, for delegation. They are optional, but
+	// ActorTokenType is required if ActorToken is set.
+	ActorToken     string
+	ActorTokenType string
+
+	// RequestedTokenType is the type of token wanted. If empty, the
+	// server chooses.
+	RequestedTokenType string
+
+	// Audience and Resource name the services where the Token will be
+	// used, by logical name and by URI. Scope narrows the Token to the
+	// given space-delimited scopes. They are optional.
+	Audience []string
+	Resource []string
+	Scope    string
+}
+
+// ExchangeToken performs a token exchange at the TokenURL, authenticating
+// as the Config's client if it has a ClientId; otherwise no client
+// credentials are sent. The type of the issued token is stored in the
+// returned Token's Extra["issued_token_type"].
+//
+//	tok, err := config.ExchangeToken(&oauth.TokenExchangeRequest{
+//		SubjectToken:     incomingAccessToken,
+//		SubjectTokenType: oauth.TokenTypeAccessToken,
+//		Audience:         []string{"backend"},
+//	})
+func (c *Config) ExchangeToken(req *TokenExchangeRequest) (*Token, error) {
+	return c.ExchangeTokenContext(context.Background(), req)
+}
+
+// ExchangeTokenContext is like ExchangeToken but uses ctx for the
+// request to the TokenURL.
+func (c *Config) ExchangeTokenContext(ctx context.Context, req *TokenExchangeRequest) (*Token, error) {
+	return c.exchangeToken(ctx, nil, req)
+}
+
+func (c *Config) exchangeToken(ctx context.Context, rt http.RoundTripper, req *TokenExchangeRequest) (*Token, error) {
+	if req.SubjectToken == "" || req.SubjectTokenType == "" {
+		return nil, OAuthError{"ExchangeToken", "no subject token supplied"}
+	}
+	if req.ActorToken != "" && req.ActorTokenType == "" {
+		return nil, OAuthError{"ExchangeToken", "no actor token type supplied"}
+	}
+	v := url.Values{
+		"grant_type":         {tokenExchangeGrantType},
+		"subject_token":      {req.SubjectToken},
+		"subject_token_type": {req.SubjectTokenType},
+	}
+	if req.ActorToken != "" {
+		v.Set("actor_token", req.ActorToken)
+		v.Set("actor_token_type", req.ActorTokenType)
+	}
+	if req.RequestedTokenType != "" {
+		v.Set("requested_token_type", req.RequestedTokenType)
+	}
+	if len(req.Audience) > 0 {
+		v["audience"] = req.Audience
+	}
+	if len(req.Resource) > 0 {
+		v["resource"] = req.Resource
+	}
+	if req.Scope != "" {
+		v.Set("scope", req.Scope)
+	}
+	t := &Transport{Config: c, Transport: rt}
+	tok := new(Token)
+	if err := t.updateToken(ctx, tok, v); err != nil {
+		return nil, err
+	}
+	return tok, nil
+}
+
+// TokenExchangeSource returns a TokenSource that performs the token
+// exchange described by req when a Token is first needed, and again
+// each time the Token expires. Requests to the TokenURL are made with
+// rt, or http.DefaultTransport if rt is nil.
+func (c *Config) TokenExchangeSource(req *TokenExchangeRequest, rt http.RoundTripper) TokenSource {
+	r := *req
+	return ReuseTokenSource(nil, &tokenExchangeSource{config: c, req: &r, rt: rt})
+}
+
+type tokenExchangeSource struct {
+	config *Config
+	req    *TokenExchangeRequest
+	rt     http.RoundTripper
+}
+
+func (s *tokenExchangeSource) Token() (*Token, error) {
+	return s.TokenContext(context.Background())
+}
+
+func (s *tokenExchangeSource) TokenContext(ctx context.Context) (*Token, error) {
+	return s.config.exchangeToken(ctx, s.rt, s.req)
+}
@@ -0,0 +1,142 @@
+// Copyright 2014 The goauth2 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oauth
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExchangeToken(t *testing.T) {
+	want := map[string]string{
+		"grant_type":           tokenExchangeGrantType,
+		"subject_token":        "subj",
+		"subject_token_type":   TokenTypeAccessToken,
+		"actor_token":          "act",
+		"actor_token_type":     TokenTypeJWT,
+		"requested_token_type": TokenTypeAccessToken,
+		"audience":             "a1,a2",
+		"resource":             "https://example.net/api",
+		"scope":                "read",
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		for k, v := range want {
+			if g := strings.Join(r.PostForm[k], ","); g != v {
+				t.Errorf("%s = %q, want %q", k, g, v)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"exchanged","token_type":"Bearer","issued_token_type":%q,"expires_in":3600}`, TokenTypeAccessToken)
+	}))
+	defer server.Close()
+
+	c := &Config{ClientId: "cl13nt1d", TokenURL: server.URL}
+	tok, err := c.ExchangeToken(&TokenExchangeRequest{
+		SubjectToken:       "subj",
+		SubjectTokenType:   TokenTypeAccessToken,
+		ActorToken:         "act",
+		ActorTokenType:     TokenTypeJWT,
+		RequestedTokenType: TokenTypeAccessToken,
+		Audience:           []string{"a1", "a2"},
+		Resource:           []string{"https://example.net/api"},
+		Scope:              "read",
+	})
+	if err != nil {
+		t.Fatalf("ExchangeToken: %v", err)
+	}
+	if tok.AccessToken != "exchanged" {
+		t.Errorf("AccessToken = %q, want %q", tok.AccessToken, "exchanged")
+	}
+	if g := tok.Extra["issued_token_type"]; g != TokenTypeAccessToken {
+		t.Errorf(`Extra["issued_token_type"] = %q, want %q`, g, TokenTypeAccessToken)
+	}
+	if tok.Expiry.IsZero() {
+		t.Errorf("Expiry is zero, want about an hour from now")
+	}
+
+	if _, err := c.ExchangeToken(&TokenExchangeRequest{SubjectToken: "subj"}); err == nil {
+		t.Errorf("ExchangeToken without SubjectTokenType succeeded, want error")
+	}
+	if _, err := c.ExchangeToken(&TokenExchangeRequest{
+		SubjectToken:     "subj",
+		SubjectTokenType: TokenTypeAccessToken,
+		ActorToken:       "act",
+	}); err == nil {
+		t.Errorf("ExchangeToken without ActorTokenType succeeded, want error")
+	}
+}
+
+// Without a ClientId, no client credentials are sent.
+func TestExchangeTokenNoClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h := r.Header.Get("Authorization"); h != "" {
+			t.Errorf("Authorization = %q, want none", h)
+		}
+		r.ParseForm()
+		for _, k := range []string{"client_id", "client_secret"} {
+			if _, ok := r.PostForm[k]; ok {
+				t.Errorf("%s sent, want none", k)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"access_token":"exchanged","expires_in":3600}`)
+	}))
+	defer server.Close()
+
+	c := &Config{TokenURL: server.URL}
+	req := &TokenExchangeRequest{SubjectToken: "subj", SubjectTokenType: TokenTypeAccessToken}
+	if _, err := c.ExchangeToken(req); err != nil {
+		t.Fatalf("ExchangeToken: %v", err)
+	}
+}
+
+func TestTokenExchangeSource(t *testing.T) {
+	server, exchanges := newCountingServer(func(w http.ResponseWriter, r *http.Request, n int) {
+		if g, w := r.FormValue("subject_token"), "subj"; g != w {
+			t.Errorf("subject_token = %q, want %q", g, w)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"exchanged%d","issued_token_type":%q,"expires_in":3600}`, n, TokenTypeAccessToken)
+	})
+	defer server.Close()
+
+	c := &Config{TokenURL: server.URL}
+	req := &TokenExchangeRequest{SubjectToken: "subj", SubjectTokenType: TokenTypeIDToken}
+	src := c.TokenExchangeSource(req, nil)
+	// Changes to req after the call do not affect the TokenSource.
+	req.SubjectToken = "other"
+
+	if n := exchanges(); n != 0 {
+		t.Errorf("exchanged %d times before the first Token, want 0", n)
+	}
+	for i := 0; i < 2; i++ {
+		tok, err := src.Token()
+		if err != nil {
+			t.Fatalf("Token: %v", err)
+		}
+		if tok.AccessToken != "exchanged1" {
+			t.Errorf("AccessToken = %q, want %q", tok.AccessToken, "exchanged1")
+		}
+	}
+
+	// An expired Token is exchanged again.
+	rs := src.(*reuseTokenSource)
+	rs.mu.Lock()
+	rs.tok.Expiry = time.Now().Add(-time.Hour)
+	rs.mu.Unlock()
+	tok, err := src.Token()
+	if err != nil {
+		t.Fatalf("Token: %v", err)
+	}
+	if tok.AccessToken != "exchanged2" {
+		t.Errorf("AccessToken = %q, want %q", tok.AccessToken, "exchanged2")
+	}
+}
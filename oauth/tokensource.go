@@ -0,0 +1,144 @@
+// Copyright 2014 The goauth2 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oauth
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// A TokenSource supplies valid Tokens, renewing them as needed. It lets
+// code accept any kind of credentials: a Transport's user Token (see
+// Transport.TokenSource), a service account (see the jwt and
+// serviceaccount packages) or a fixed Token.
+//
+// A TokenSource may also implement
+//
+//	TokenContext(ctx context.Context) (*Token, error)
+//
+// in which case SourceTransport uses it with the request's context.
+type TokenSource interface {
+	Token() (*Token, error)
+}
+
+// contextTokenSource is a TokenSource that accepts a context.
+type contextTokenSource interface {
+	TokenContext(ctx context.Context) (*Token, error)
+}
+
+// TokenFromSource gets a Token from src, passing ctx to it if src
+// implements TokenContext.
+func TokenFromSource(ctx context.Context, src TokenSource) (*Token, error) {
+	if s, ok := src.(contextTokenSource); ok {
+		return s.TokenContext(ctx)
+	}
+	return src.Token()
+}
+
+// StaticTokenSource returns a TokenSource that always returns tok. It is
+// never renewed.
+func StaticTokenSource(tok *Token) TokenSource {
+	return staticTokenSource{tok}
+}
+
+type staticTokenSource struct {
+	tok *Token
+}
+
+func (s staticTokenSource) Token() (*Token, error) {
+	return s.tok, nil
+}
+
+// ReuseTokenSource returns a TokenSource that returns tok, which may be
+// nil, until it expires, and then gets a new Token from src and returns
+// that until it expires. Concurrent callers share a single call to src.
+func ReuseTokenSource(tok *Token, src TokenSource) TokenSource {
+	return &reuseTokenSource{tok: tok, src: src}
+}
+
+type reuseTokenSource struct {
+	src TokenSource
+
+	mu  sync.Mutex
+	tok *Token
+}
+
+func (s *reuseTokenSource) Token() (*Token, error) {
+	return s.TokenContext(context.Background())
+}
+
+func (s *reuseTokenSource) TokenContext(ctx context.Context) (*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tok != nil && !s.tok.Expired() {
+		return s.tok, nil
+	}
+	tok, err := TokenFromSource(ctx, s.src)
+	if err != nil {
+		return nil, err
+	}
+	s.tok = tok
+	return tok, nil
+}
+
+// TokenSource returns a TokenSource for the Transport's Token, which is
+// refreshed as it would be by RoundTrip.
+func (t *Transport) TokenSource() TokenSource {
+	return transportTokenSource{t}
+}
+
+type transportTokenSource struct {
+	t *Transport
+}
+
+func (s transportTokenSource) Token() (*Token, error) {
+	return s.t.getToken(context.Background())
+}
+
+func (s transportTokenSource) TokenContext(ctx context.Context) (*Token, error) {
+	return s.t.getToken(ctx)
+}
+
+// SourceTransport is an http.RoundTripper that authorizes requests with
+// Tokens from a TokenSource.
+//
+//	client := &http.Client{Transport: &oauth.SourceTransport{Source: src}}
+//	client.Get(...)
+type SourceTransport struct {
+	Source TokenSource
+
+	// Base is the HTTP transport used to make the requests.
+	// It will default to http.DefaultTransport if nil.
+	Base http.RoundTripper
+}
+
+// NewClient returns an *http.Client that authorizes its requests with
+// Tokens from src.
+func NewClient(src TokenSource) *http.Client {
+	return &http.Client{Transport: &SourceTransport{Source: src}}
+}
+
+// RoundTrip gets a Token from the Source, using the request's context,
+// and sends the request with it.
+func (t *SourceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.Source == nil {
+		return nil, OAuthError{"RoundTrip", "no TokenSource supplied"}
+	}
+	tok, err := TokenFromSource(req.Context(), t.Source)
+	if err != nil {
+		return nil, err
+	}
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	// To set the Authorization header, we must make a copy of the Request
+	// so that we don't modify the Request we were given.
+	// This is required by the specification of http.RoundTripper.
+	req = cloneRequest(req)
+	req.Header.Set("Authorization", "Bearer "+tok.AccessToken)
+	return base.RoundTrip(req)
+}
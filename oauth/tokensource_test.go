@@ -0,0 +1,104 @@
+// Copyright 2014 The goauth2 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingSource returns a new Token, valid for d, on each call.
+type countingSource struct {
+	mu sync.Mutex
+	n  int
+	d  time.Duration
+}
+
+func (s *countingSource) Token() (*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.n++
+	time.Sleep(time.Millisecond)
+	return &Token{AccessToken: fmt.Sprintf("token%d", s.n), Expiry: time.Now().Add(s.d)}, nil
+}
+
+func TestReuseTokenSource(t *testing.T) {
+	src := &countingSource{d: time.Hour}
+	reuse := ReuseTokenSource(&Token{AccessToken: "token0", Expiry: time.Now().Add(-time.Second)}, src)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tok, err := reuse.Token()
+			if err != nil || tok.AccessToken != "token1" {
+				t.Errorf("Token = %+v, %v, want token1", tok, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if src.n != 1 {
+		t.Errorf("source called %d times, want 1", src.n)
+	}
+
+	src = &countingSource{d: -time.Second}
+	reuse = ReuseTokenSource(nil, src)
+	reuse.Token()
+	if tok, _ := reuse.Token(); tok.AccessToken != "token2" {
+		t.Errorf("expired Token was reused: %+v", tok)
+	}
+}
+
+func TestSourceTransport(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Header.Get("Authorization"))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	client := NewClient(StaticTokenSource(&Token{AccessToken: "static"}))
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	r, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	b, _ := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if g, w := string(b), "Bearer static"; g != w {
+		t.Errorf("Authorization = %q, want %q", g, w)
+	}
+	if req.Header.Get("Authorization") != "" {
+		t.Errorf("SourceTransport modified the request")
+	}
+
+	// The request's context is passed to the source.
+	server2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"access_token":"token2","expires_in":3600}`)
+	}))
+	defer server2.Close()
+	transport := &Transport{
+		Config: &Config{TokenURL: server2.URL + "/token"},
+		Token:  &Token{AccessToken: "token1", RefreshToken: "r", Expiry: time.Now().Add(-time.Hour)},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, _ = http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+	if _, err := NewClient(transport.TokenSource()).Do(req); !errors.Is(err, context.Canceled) {
+		t.Errorf("Do with cancelled context = %v, want %v", err, context.Canceled)
+	}
+	tok, err := transport.TokenSource().Token()
+	if err != nil || tok.AccessToken != "token2" {
+		t.Errorf("Transport.TokenSource().Token() = %+v, %v, want token2", tok, err)
+	}
+}
@@ -0,0 +1,277 @@
+// Copyright 2014 The goauth2 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oauth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// defaultFlowMaxAge is used in place of a zero WebFlow.MaxAge.
+const defaultFlowMaxAge = 10 * time.Minute
+
+// WebFlow implements the authorization code flow for web servers as a
+// pair of handlers. The login handler redirects the user to the AuthURL
+// with a random state, PKCE code verifier and nonce, which it stores in
+// a signed, expiring cookie. The callback handler, served at the
+// Config's RedirectURL, checks the state against the cookie, exchanges
+// the code and passes the Token to Success.
+//
+//	flow := &oauth.WebFlow{
+//		Config: config,
+//		Key:    key, // 32 random bytes, kept secret
+//		Success: func(w http.ResponseWriter, r *http.Request, tok *oauth.Token, nonce string) {
+//			// Verify tok.Extra["id_token"] using nonce, store tok, and
+//			// start the user's session.
+//		},
+//	}
+//	http.Handle("/login", flow.LoginHandler())
+//	http.Handle("/handler", flow.CallbackHandler())
+//
+// The handlers never read or write the Config's TokenCache, which would
+// be shared between users; Success should store the Token.
+type WebFlow struct {
+	Config *Config
+
+	// Key is the secret used to sign the state cookie with HMAC-SHA256.
+	// It should be at least 32 random bytes.
+	Key []byte
+
+	// Success is called with the Token obtained by the callback handler
+	// and the nonce sent in the authorization request. It must write the
+	// response.
+	Success func(w http.ResponseWriter, r *http.Request, tok *Token, nonce string)
+
+	// Error, if not nil, is called when the callback handler fails and
+	// must write the response. By default the error is reported with
+	// status 400 for a bad callback request and 500 otherwise.
+	Error func(w http.ResponseWriter, r *http.Request, err error)
+
+	// Options are added to the authorization URL. Note that the state
+	// cookie is not sent with the cross-site POST made for the
+	// "form_post" ResponseMode.
+	Options []AuthCodeOption
+
+	// CookieName is the name of the state cookie.
+	// It will default to "oauth_state" if empty.
+	CookieName string
+
+	// MaxAge is how long the user has to complete the authorization.
+	// It will default to 10 minutes if zero.
+	MaxAge time.Duration
+
+	// Transport is used for the request to the TokenURL.
+	// It will default to http.DefaultTransport if nil.
+	Transport http.RoundTripper
+}
+
+// flowState is stored in the state cookie.
+type flowState struct {
+	State    string `json:"s"`
+	Verifier string `json:"v"`
+	Nonce    string `json:"n"`
+	Expiry   int64  `json:"e"`
+}
+
+// CallbackError is passed to WebFlow.Error when the callback request is
+// invalid: the state does not match the cookie, the cookie has expired,
+// or the authorization server reported an error.
+type CallbackError struct {
+	// Code and Description are the error and error_description
+	// parameters of the callback, if any.
+	Code        string
+	Description string
+
+	msg string
+}
+
+func (e *CallbackError) Error() string {
+	if e.Code != "" {
+		s := "oauth: authorization failed: " + e.Code
+		if e.Description != "" {
+			s += ": " + e.Description
+		}
+		return s
+	}
+	return "oauth: invalid callback: " + e.msg
+}
+
+// LoginHandler returns a handler that starts the flow by redirecting to
+// the AuthURL.
+func (f *WebFlow) LoginHandler() http.Handler {
+	return http.HandlerFunc(f.login)
+}
+
+// CallbackHandler returns a handler for the Config's RedirectURL that
+// completes the flow.
+func (f *WebFlow) CallbackHandler() http.Handler {
+	return http.HandlerFunc(f.callback)
+}
+
+// errNoKey is returned by the handlers if the WebFlow has no Key, with
+// which anyone could forge the state.
+var errNoKey = OAuthError{"WebFlow", "no Key supplied"}
+
+func (f *WebFlow) login(w http.ResponseWriter, r *http.Request) {
+	if len(f.Key) == 0 {
+		http.Error(w, errNoKey.Error(), http.StatusInternalServerError)
+		return
+	}
+	var s flowState
+	for _, p := range []*string{&s.State, &s.Verifier, &s.Nonce} {
+		v, err := NewCodeVerifier()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		*p = v
+	}
+	s.Expiry = time.Now().Add(f.maxAge()).Unix()
+	opts := append([]AuthCodeOption{CodeChallengeParams(s.Verifier, ChallengeS256), Nonce(s.Nonce)}, f.Options...)
+	u, err := f.Config.AuthCodeURLWithOptions(s.State, opts...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     f.cookieName(),
+		Value:    f.sign(&s),
+		Path:     "/",
+		MaxAge:   int(f.maxAge() / time.Second),
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, u, http.StatusFound)
+}
+
+func (f *WebFlow) callback(w http.ResponseWriter, r *http.Request) {
+	// The state is single use.
+	http.SetCookie(w, &http.Cookie{
+		Name:     f.cookieName(),
+		Path:     "/",
+		MaxAge:   -1,
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+	})
+	s, err := f.checkState(r)
+	if err != nil {
+		f.fail(w, r, err)
+		return
+	}
+	if code := r.FormValue("error"); code != "" {
+		f.fail(w, r, &CallbackError{Code: code, Description: r.FormValue("error_description")})
+		return
+	}
+	code := r.FormValue("code")
+	if code == "" {
+		f.fail(w, r, &CallbackError{msg: "no code"})
+		return
+	}
+
+	// Use a copy of the Config so that Exchange does not touch the
+	// shared TokenCache.
+	config := f.Config.clone()
+	config.TokenCache = nil
+	t := &Transport{Config: config, Transport: f.Transport, CodeVerifier: s.Verifier}
+	tok, err := t.ExchangeContext(r.Context(), code)
+	if err != nil {
+		f.fail(w, r, err)
+		return
+	}
+	f.Success(w, r, tok, s.Nonce)
+}
+
+// checkState returns the flow state from the request's cookie if it is
+// valid and matches the state parameter.
+func (f *WebFlow) checkState(r *http.Request) (*flowState, error) {
+	if len(f.Key) == 0 {
+		return nil, errNoKey
+	}
+	c, err := r.Cookie(f.cookieName())
+	if err != nil {
+		return nil, &CallbackError{msg: "no state cookie"}
+	}
+	s, ok := f.verify(c.Value)
+	if !ok {
+		return nil, &CallbackError{msg: "bad state cookie"}
+	}
+	if time.Now().Unix() > s.Expiry {
+		return nil, &CallbackError{msg: "state expired"}
+	}
+	if subtle.ConstantTimeCompare([]byte(r.FormValue("state")), []byte(s.State)) != 1 {
+		return nil, &CallbackError{msg: "state mismatch"}
+	}
+	return s, nil
+}
+
+func (f *WebFlow) fail(w http.ResponseWriter, r *http.Request, err error) {
+	if f.Error != nil {
+		f.Error(w, r, err)
+		return
+	}
+	if _, ok := err.(*CallbackError); ok {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// sign encodes s as a cookie value with an HMAC.
+func (f *WebFlow) sign(s *flowState) string {
+	b, _ := json.Marshal(s)
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(f.mac(payload))
+}
+
+// verify decodes a cookie value created by sign.
+func (f *WebFlow) verify(value string) (*flowState, bool) {
+	i := strings.LastIndex(value, ".")
+	if i < 0 {
+		return nil, false
+	}
+	payload := value[:i]
+	mac, err := base64.RawURLEncoding.DecodeString(value[i+1:])
+	if err != nil || !hmac.Equal(mac, f.mac(payload)) {
+		return nil, false
+	}
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, false
+	}
+	s := new(flowState)
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, false
+	}
+	return s, true
+}
+
+func (f *WebFlow) mac(payload string) []byte {
+	h := hmac.New(sha256.New, f.Key)
+	h.Write([]byte(f.cookieName()))
+	h.Write([]byte{0})
+	h.Write([]byte(payload))
+	return h.Sum(nil)
+}
+
+func (f *WebFlow) cookieName() string {
+	if f.CookieName != "" {
+		return f.CookieName
+	}
+	return "oauth_state"
+}
+
+func (f *WebFlow) maxAge() time.Duration {
+	if f.MaxAge != 0 {
+		return f.MaxAge
+	}
+	return defaultFlowMaxAge
+}
@@ -0,0 +1,177 @@
+// Copyright 2014 The goauth2 Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oauth
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newWebFlow returns a WebFlow whose token endpoint checks the PKCE code
+// verifier against the challenge sent by the last login.
+func newWebFlow(t *testing.T) (*WebFlow, *httptest.Server) {
+	var challenge string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.FormValue("code") != "c0d3" || s256(r.FormValue("code_verifier")) != challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, `{"error":"invalid_grant"}`)
+			return
+		}
+		io.WriteString(w, `{"access_token":"token1","refresh_token":"refreshtoken1","expires_in":3600}`)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	flow := &WebFlow{
+		Config: &Config{
+			ClientId:    "cl13nt1d",
+			AuthURL:     server.URL + "/auth",
+			TokenURL:    server.URL + "/token",
+			RedirectURL: "https://client.example.net/callback",
+			TokenCache:  &memCache{tok: &Token{RefreshToken: "someone else's"}},
+		},
+		Key: []byte("0123456789abcdef0123456789abcdef"),
+		Options: []AuthCodeOption{LoginHint("user@example.net"), func(v url.Values) error {
+			challenge = v.Get("code_challenge")
+			return nil
+		}},
+	}
+	return flow, server
+}
+
+// login runs the login handler and returns the authorization URL and
+// the state cookie.
+func login(t *testing.T, flow *WebFlow) (*url.URL, *http.Cookie) {
+	w := httptest.NewRecorder()
+	flow.LoginHandler().ServeHTTP(w, httptest.NewRequest("GET", "/login", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("login status = %d, want %d", w.Code, http.StatusFound)
+	}
+	u, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("parsing Location: %v", err)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || !cookies[0].HttpOnly {
+		t.Fatalf("login cookies = %v, want one HttpOnly cookie", cookies)
+	}
+	return u, cookies[0]
+}
+
+// callback runs the callback handler with the given query and cookie.
+func callback(flow *WebFlow, query string, c *http.Cookie) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "/callback?"+query, nil)
+	if c != nil {
+		r.AddCookie(c)
+	}
+	w := httptest.NewRecorder()
+	flow.CallbackHandler().ServeHTTP(w, r)
+	return w
+}
+
+func TestWebFlow(t *testing.T) {
+	flow, server := newWebFlow(t)
+	defer server.Close()
+	var got *Token
+	var gotNonce string
+	flow.Success = func(w http.ResponseWriter, r *http.Request, tok *Token, nonce string) {
+		got, gotNonce = tok, nonce
+		io.WriteString(w, "welcome")
+	}
+
+	u, c := login(t, flow)
+	if g, w := u.Path, "/auth"; g != w {
+		t.Errorf("redirected to %q, want %q", g, w)
+	}
+	q := u.Query()
+	state, nonce := q.Get("state"), q.Get("nonce")
+	if state == "" || nonce == "" || q.Get("code_challenge") == "" {
+		t.Fatalf("AuthCodeURL %q lacks state, nonce or code_challenge", u)
+	}
+	if g, w := q.Get("code_challenge_method"), ChallengeS256; g != w {
+		t.Errorf("code_challenge_method = %q, want %q", g, w)
+	}
+	if g, w := q.Get("login_hint"), "user@example.net"; g != w {
+		t.Errorf("login_hint = %q, want %q", g, w)
+	}
+	w := callback(flow, url.Values{"code": {"c0d3"}, "state": {state}}.Encode(), c)
+	if w.Code != http.StatusOK {
+		t.Fatalf("callback status = %d (%s), want 200", w.Code, w.Body)
+	}
+	if got == nil {
+		t.Fatalf("Success was not called")
+	}
+	checkToken(t, got, "token1", "refreshtoken1", "")
+	if gotNonce != nonce {
+		t.Errorf("Success nonce = %q, want %q", gotNonce, nonce)
+	}
+	if cache := flow.Config.TokenCache.(*memCache); cache.tok.AccessToken != "" {
+		t.Errorf("callback wrote the TokenCache")
+	}
+	if cs := w.Result().Cookies(); len(cs) != 1 || cs[0].MaxAge >= 0 {
+		t.Errorf("callback cookies = %v, want state cookie deleted", cs)
+	}
+	if u2, _ := login(t, flow); u2.Query().Get("state") == state {
+		t.Errorf("login reused state %q", state)
+	}
+}
+
+func TestWebFlowErrors(t *testing.T) {
+	flow, server := newWebFlow(t)
+	defer server.Close()
+	flow.Success = func(w http.ResponseWriter, r *http.Request, tok *Token, nonce string) {
+		t.Errorf("Success called with %+v", tok)
+	}
+	u, c := login(t, flow)
+	state := u.Query().Get("state")
+	tampered := *c
+	tampered.Value = strings.Replace(c.Value, ".", "x.", 1)
+
+	tests := []struct {
+		name   string
+		query  url.Values
+		cookie *http.Cookie
+		status int
+	}{
+		{"no cookie", url.Values{"code": {"c0d3"}, "state": {state}}, nil, http.StatusBadRequest},
+		{"bad state", url.Values{"code": {"c0d3"}, "state": {"foo"}}, c, http.StatusBadRequest},
+		{"tampered", url.Values{"code": {"c0d3"}, "state": {state}}, &tampered, http.StatusBadRequest},
+		{"no code", url.Values{"state": {state}}, c, http.StatusBadRequest},
+		{"denied", url.Values{"error": {"access_denied"}, "state": {state}}, c, http.StatusBadRequest},
+		{"bad code", url.Values{"code": {"wrong"}, "state": {state}}, c, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		if w := callback(flow, tt.query.Encode(), tt.cookie); w.Code != tt.status {
+			t.Errorf("%s: callback status = %d (%s), want %d", tt.name, w.Code, w.Body, tt.status)
+		}
+	}
+
+	// Expired state.
+	flow.MaxAge = -time.Minute
+	u, c = login(t, flow)
+	var err error
+	flow.Error = func(w http.ResponseWriter, r *http.Request, e error) {
+		err = e
+		w.WriteHeader(http.StatusForbidden)
+	}
+	w := callback(flow, url.Values{"code": {"c0d3"}, "state": {u.Query().Get("state")}}.Encode(), c)
+	var ce *CallbackError
+	if w.Code != http.StatusForbidden || !errors.As(err, &ce) || !strings.Contains(ce.Error(), "expired") {
+		t.Errorf("expired callback: status %d, error %v; want 403, state expired", w.Code, err)
+	}
+
+	// Errors reported by the authorization server.
+	flow.MaxAge = 0
+	u, c = login(t, flow)
+	callback(flow, url.Values{"error": {"access_denied"}, "error_description": {"no thanks"}, "state": {u.Query().Get("state")}}.Encode(), c)
+	if !errors.As(err, &ce) || ce.Code != "access_denied" || ce.Description != "no thanks" {
+		t.Errorf("denied callback error = %v, want access_denied", err)
+	}
+}